@@ -0,0 +1,79 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/BurntSushi/toml"
+)
+
+// flagEnv maps flags to the environment variables that set their defaults;
+// these take precedence over the config file
+var flagEnv = map[string]string{
+	"no-download": "IP_PLUS_OFFLINE",
+	"offline":     "IP_PLUS_OFFLINE",
+	"db":          "IP_PLUS_DB",
+	"db-sha256":   "IP_PLUS_DB_SHA256",
+	"max-age":     "IP_PLUS_MAX_AGE",
+	"asn-db":      "IP_PLUS_ASN_DB",
+	"quiet":       "IP_PLUS_QUIET",
+}
+
+// defaultConfigPath returns $XDG_CONFIG_HOME/ip-plus/config.toml,
+// falling back to the OS user config directory
+func defaultConfigPath() string {
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		var err error
+		if dir, err = os.UserConfigDir(); err != nil {
+			return ""
+		}
+	}
+	return filepath.Join(dir, "ip-plus", "config.toml")
+}
+
+// loadConfig applies a TOML config file to the flags of fs
+//
+// Keys are long flag names, e.g. `color = "always"` or `foreign-only = true`.
+// Flags given on the command line or through their environment variable
+// keep their value, so the priority is flags, then env, then file.
+// A missing file is only an error if required is set.
+func loadConfig(fs *flag.FlagSet, path string, required bool) error {
+	values := map[string]any{}
+	if _, err := toml.DecodeFile(path, &values); err != nil {
+		if os.IsNotExist(err) && !required {
+			return nil
+		}
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
+	// Flags set on the command line win over the file
+	explicit := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+	})
+
+	for name, value := range values {
+		if fs.Lookup(name) == nil {
+			return fmt.Errorf("unknown option in config %s: %s", path, name)
+		}
+		if explicit[name] || (flagEnv[name] != "" && os.Getenv(flagEnv[name]) != "") {
+			continue
+		}
+
+		// Lists set repeatable flags once per element
+		items, ok := value.([]any)
+		if !ok {
+			items = []any{value}
+		}
+		for _, item := range items {
+			if err := fs.Set(name, fmt.Sprint(item)); err != nil {
+				return fmt.Errorf("invalid value for %s in config %s: %w", name, path, err)
+			}
+		}
+	}
+
+	return nil
+}
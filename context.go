@@ -0,0 +1,48 @@
+package main
+
+// contextSeparator goes between groups of lines that aren't adjacent,
+// like grep -C prints
+const contextSeparator = "--\n"
+
+// contextWindow selects lines with foreign hits and the size lines
+// before and after each, like grep -C
+type contextWindow struct {
+	size    int
+	before  []string // recent lines not printed yet, at most size
+	after   int      // lines still to print after the last hit
+	printed bool     // a group has been printed
+	gap     bool     // lines were skipped since the last printed one
+}
+
+// newContextWindow creates a window of size lines around each hit,
+// size must be positive
+func newContextWindow(size int) *contextWindow {
+	return &contextWindow{size: size}
+}
+
+// filter takes the next line and returns the lines to print now
+func (c *contextWindow) filter(line string, hit bool) []string {
+	switch {
+	case hit:
+		var out []string
+		if c.printed && c.gap {
+			out = append(out, contextSeparator)
+		}
+		out = append(out, c.before...)
+		out = append(out, line)
+		c.before, c.after, c.gap, c.printed = c.before[:0], c.size, false, true
+		return out
+	case c.after > 0:
+		c.after--
+		return []string{line}
+	default:
+		// Remember the line in case a hit follows, forgetting the oldest
+		if len(c.before) == c.size {
+			copy(c.before, c.before[1:])
+			c.before = c.before[:c.size-1]
+			c.gap = true
+		}
+		c.before = append(c.before, line)
+		return nil
+	}
+}
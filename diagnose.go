@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"ip/enrich"
+)
+
+// runTest prints how each input line's IPs are matched and resolved,
+// without spawning a command
+func runTest(r io.Reader, w io.Writer, enricher *enrich.Enricher, maxLine int) error {
+	scanner, _ := newLineScanner(r, maxLine)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := scanner.Text()
+		results := enricher.Resolve(line)
+
+		fmt.Fprintf(w, "line %d: %s\n", lineNum, line)
+		if len(results) == 0 {
+			fmt.Fprintf(w, "  (no IPs)\n")
+			continue
+		}
+
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintf(tw, "  IP\tSTART\tEND\tSPECIAL\tLOCATION\n")
+		for _, result := range results {
+			fmt.Fprintf(tw, "  %s\t%d\t%d\t%t\t%s\n",
+				result.IP, result.Start, result.End, result.Special, result.Label)
+		}
+		tw.Flush()
+	}
+
+	return scanner.Err()
+}
+
+// runExplain prints the raw database record of one IP next to the
+// verdicts and the annotation ip-plus derives from it
+func runExplain(w io.Writer, db enrich.Backend, enricher *enrich.Enricher, ip string) error {
+	ip = strings.Trim(ip, "[]")
+	if net.ParseIP(ip) == nil {
+		return fmt.Errorf("invalid IP: %s", ip)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "IP:\t%s\n", ip)
+	fmt.Fprintf(tw, "Special:\t%t\n", enrich.IsSpecialIP(ip))
+	fmt.Fprintf(tw, "Reserved:\t%t\n", enrich.IsReservedIP(ip))
+
+	// The record as the database returned it, before formatting
+	loc, err := db.Query(ip)
+	switch {
+	case err != nil:
+		fmt.Fprintf(tw, "Query error:\t%v\n", err)
+	case loc == nil:
+		fmt.Fprintf(tw, "Query result:\t(none)\n")
+	default:
+		fmt.Fprintf(tw, "Country:\t%q\n", loc.Country)
+		fmt.Fprintf(tw, "Province:\t%q\n", loc.Province)
+		fmt.Fprintf(tw, "City:\t%q\n", loc.City)
+		fmt.Fprintf(tw, "District:\t%q\n", loc.District)
+		fmt.Fprintf(tw, "ISP:\t%q\n", loc.ISP)
+		fmt.Fprintf(tw, "Record IP:\t%q\n", loc.IP)
+	}
+
+	results := enricher.Resolve(ip)
+	if len(results) == 0 {
+		fmt.Fprintf(tw, "Label:\t(not matched or filtered out)\n")
+	} else {
+		fmt.Fprintf(tw, "Label:\t%s\n", results[0].Label)
+		fmt.Fprintf(tw, "Foreign:\t%t\n", results[0].IsForeign())
+	}
+	fmt.Fprintf(tw, "Output:\t%s\n", enricher.Render(ip, results))
+
+	return tw.Flush()
+}
+
+// runDBInfo prints the path, size and age of the database file and
+// the metadata the backend reports about it
+func runDBInfo(w io.Writer, path, backend string, db enrich.Backend) error {
+	stat, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("failed to stat database: %w", err)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "Path:\t%s\n", path)
+	fmt.Fprintf(tw, "Backend:\t%s\n", backend)
+	fmt.Fprintf(tw, "Size:\t%d bytes (%.2f MB)\n", stat.Size(), float64(stat.Size())/(1024*1024))
+	fmt.Fprintf(tw, "Modified:\t%s (%d days ago)\n",
+		stat.ModTime().Format(time.DateTime), int(time.Since(stat.ModTime()).Hours()/24))
+
+	if describer, ok := db.(enrich.Describer); ok {
+		info := describer.Info()
+		if info.Format != "" {
+			fmt.Fprintf(tw, "Format:\t%s\n", info.Format)
+		}
+		if !info.Built.IsZero() {
+			fmt.Fprintf(tw, "Built:\t%s\n", info.Built.Format(time.DateTime))
+		}
+		if info.Records > 0 {
+			fmt.Fprintf(tw, "Records:\t%d\n", info.Records)
+		}
+		if info.Version != "" {
+			fmt.Fprintf(tw, "Version:\t%s\n", info.Version)
+		}
+	}
+	return tw.Flush()
+}
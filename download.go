@@ -0,0 +1,486 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"ip/enrich"
+)
+
+// Built-in download URLs, tried in order
+var ipdbDownloadURLs = []string{
+	"https://cdn.jsdelivr.net/npm/qqwry.raw.ipdb/qqwry.ipdb",
+	"https://fastly.jsdelivr.net/npm/qqwry.raw.ipdb/qqwry.ipdb",
+	"https://unpkg.com/qqwry.raw.ipdb/qqwry.ipdb",
+	"https://registry.npmmirror.com/qqwry.raw.ipdb/latest/files/qqwry.ipdb",
+}
+
+// Timeout for connecting to a server or proxy
+const connectTimeout = 10 * time.Second
+
+// httpClient is used for all downloads; it honors HTTP_PROXY, HTTPS_PROXY
+// and NO_PROXY, and fails fast when a host or proxy is unreachable
+var httpClient = &http.Client{
+	Transport: &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   connectTimeout,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		TLSHandshakeTimeout:   connectTimeout,
+		ResponseHeaderTimeout: 30 * time.Second,
+	},
+}
+
+const (
+	// Suffix of the sidecar file holding the expected SHA-256
+	checksumSuffix = ".sha256"
+	// Suffix of the partially downloaded database
+	partialSuffix = ".part"
+	// Suffix of the file next to the partial database recording where
+	// it came from, so it is only resumed from the same file
+	resumeSuffix = ".resume"
+)
+
+// downloader fetches the IP database
+type downloader struct {
+	timeout  time.Duration // time limit for each download attempt
+	retries  int           // extra attempts per URL after transient failures
+	quiet    bool          // only report warnings and errors
+	progress bool          // show a progress meter, for terminals
+	checksum string        // expected SHA-256 of the database, from --db-sha256
+}
+
+// infof prints a progress message to stderr unless quiet
+func (d *downloader) infof(format string, args ...any) {
+	if !d.quiet {
+		fmt.Fprintf(os.Stderr, format, args...)
+	}
+}
+
+// transientError marks a download failure that is worth retrying
+type transientError struct {
+	err error
+}
+
+func (e transientError) Error() string { return e.err.Error() }
+func (e transientError) Unwrap() error { return e.err }
+
+// ensureIPDB checks if IP database exists, downloads if not,
+// and refreshes it when it is older than maxAge (0 disables refreshing)
+func (d *downloader) ensureIPDB(ipdbPath string, maxAge time.Duration) error {
+	// Check if file exists
+	info, err := os.Stat(ipdbPath)
+	if err != nil {
+		return d.downloadIPDB(ipdbPath)
+	}
+
+	// Refresh stale database, keeping the existing file if that fails
+	age := time.Since(info.ModTime())
+	slog.Debug("database found", "path", ipdbPath, "age", age.Round(time.Second), "max_age", maxAge)
+	if maxAge > 0 && age > maxAge {
+		d.infof("IP database is %d days old, refreshing...\n", int(age.Hours()/24))
+		if err := d.downloadIPDB(ipdbPath); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: refresh failed, using existing database: %v\n", err)
+		}
+	}
+
+	return nil
+}
+
+// downloadURLs returns the URLs to download the database from:
+// those in IP_PLUS_DB_URL (comma separated) first, then the built-in ones
+func downloadURLs() []string {
+	urls := []string{}
+	for _, url := range strings.Split(os.Getenv("IP_PLUS_DB_URL"), ",") {
+		if url = strings.TrimSpace(url); url != "" {
+			urls = append(urls, url)
+		}
+	}
+	return append(urls, ipdbDownloadURLs...)
+}
+
+// downloadIPDB downloads the IP database to ipdbPath, trying each URL in turn
+func (d *downloader) downloadIPDB(ipdbPath string) error {
+	failures := []string{}
+	for _, url := range downloadURLs() {
+		err := d.downloadWithRetry(url, ipdbPath)
+		if err == nil {
+			return nil
+		}
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+		failures = append(failures, fmt.Sprintf("  %s: %v", url, err))
+	}
+
+	return fmt.Errorf("failed to download IP database from any URL:\n%s", strings.Join(failures, "\n"))
+}
+
+// downloadWithRetry downloads from url, retrying transient failures with
+// exponential backoff; each retry resumes from the partial file
+func (d *downloader) downloadWithRetry(url, ipdbPath string) error {
+	backoff := time.Second
+	for attempt := 0; ; attempt++ {
+		err := d.downloadFrom(url, ipdbPath)
+		var transient transientError
+		if err == nil || !errors.As(err, &transient) || attempt >= d.retries {
+			return err
+		}
+
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+		fmt.Fprintf(os.Stderr, "Retrying in %s (attempt %d of %d)...\n", backoff, attempt+2, d.retries+1)
+		slog.Warn("download failed, retrying", "url", url, "err", err, "backoff", backoff, "attempt", attempt+2)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// downloadFrom downloads the IP database from url to ipdbPath
+//
+// Data is written to a ".part" file next to the database that is kept when
+// the download fails, so the next attempt can resume it with a Range request.
+// Only a partial file from the same URL is resumed, and only with If-Range
+// on the validator the server first sent, so a changed file starts over.
+func (d *downloader) downloadFrom(url, ipdbPath string) error {
+	partPath := ipdbPath + partialSuffix
+
+	// Download the database
+	d.infof("Downloading IP database from %s...\n", url)
+	slog.Info("download started", "url", url, "path", ipdbPath)
+	started := time.Now()
+
+	// Resume a previous partial download if there is one of the same
+	// file, as far as the server's validator tells
+	offset := int64(0)
+	validator, ok := readResumeInfo(partPath, url)
+	if info, err := os.Stat(partPath); err == nil && ok {
+		offset = info.Size()
+	} else if err == nil {
+		slog.Debug("discarding partial download", "path", partPath)
+		removePartial(partPath)
+	}
+
+	ctx := context.Background()
+	if d.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d.timeout)
+		defer cancel()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return fmt.Errorf("failed to download IP database: %w", err)
+	}
+	if offset > 0 {
+		// If-Range makes the server send the whole file if it changed
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		req.Header.Set("If-Range", validator)
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return transientError{fmt.Errorf("failed to download IP database: %w", err)}
+	}
+	defer resp.Body.Close()
+
+	flags := os.O_CREATE | os.O_WRONLY
+	switch {
+	case resp.StatusCode == http.StatusPartialContent &&
+		strings.HasPrefix(resp.Header.Get("Content-Range"), fmt.Sprintf("bytes %d-", offset)):
+		flags |= os.O_APPEND
+		d.infof("Resuming download at %.2f MB\n", float64(offset)/(1024*1024))
+	case resp.StatusCode == http.StatusOK:
+		// Full response, either fresh or the server ignored the Range header
+		flags |= os.O_TRUNC
+		offset = 0
+	case resp.StatusCode == http.StatusRequestedRangeNotSatisfiable:
+		// Stale partial file, start over on the next attempt
+		removePartial(partPath)
+		return transientError{fmt.Errorf("failed to resume download: HTTP %d", resp.StatusCode)}
+	case resp.StatusCode >= 500:
+		return transientError{fmt.Errorf("failed to download IP database: HTTP %d", resp.StatusCode)}
+	default:
+		return fmt.Errorf("failed to download IP database: HTTP %d", resp.StatusCode)
+	}
+
+	// Open partial file, recording what a later attempt may resume
+	if offset == 0 {
+		if err := writeResumeInfo(partPath, url, resp.Header); err != nil {
+			return err
+		}
+	}
+	partFile, err := os.OpenFile(partPath, flags, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to create temp file: %w", err)
+	}
+
+	// Download with progress
+	totalSize := resp.ContentLength
+	if totalSize > 0 {
+		totalSize += offset
+	}
+	downloaded := offset
+	buffer := make([]byte, 32*1024) // 32KB buffer
+	showProgress := d.progress && !d.quiet && totalSize > 0
+
+	for {
+		n, err := resp.Body.Read(buffer)
+		if n > 0 {
+			if _, writeErr := partFile.Write(buffer[:n]); writeErr != nil {
+				partFile.Close()
+				return fmt.Errorf("failed to write to temp file: %w", writeErr)
+			}
+			downloaded += int64(n)
+
+			if showProgress {
+				fmt.Fprintf(os.Stderr, "\rDownloading: %.2f MB / %.2f MB (%.1f%%)",
+					float64(downloaded)/(1024*1024),
+					float64(totalSize)/(1024*1024),
+					float64(downloaded)*100/float64(totalSize))
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			// Keep the partial file so the download can be resumed
+			partFile.Close()
+			if showProgress {
+				fmt.Fprintf(os.Stderr, "\n")
+			}
+			return transientError{fmt.Errorf("failed to download: %w", err)}
+		}
+	}
+
+	partFile.Close()
+	if showProgress {
+		fmt.Fprintf(os.Stderr, "\n")
+	}
+
+	// A connection closed early must not install a truncated database
+	if totalSize > 0 && downloaded != totalSize {
+		removePartial(partPath)
+		return transientError{fmt.Errorf("incomplete download: got %d of %d bytes", downloaded, totalSize)}
+	}
+	d.infof("Download complete!\n")
+	slog.Info("download finished", "url", url, "bytes", downloaded, "duration", time.Since(started).Round(time.Millisecond))
+
+	// Verify integrity before installing the file
+	if err := verifyDownload(partPath, url+checksumSuffix, d.checksum); err != nil {
+		removePartial(partPath)
+		return err
+	}
+
+	// Store the database compressed if its name asks for it
+	if strings.EqualFold(filepath.Ext(ipdbPath), enrich.GzipExt) {
+		if err := gzipFile(partPath); err != nil {
+			removePartial(partPath)
+			return err
+		}
+	}
+	os.Remove(partPath + resumeSuffix)
+
+	// Rename partial file to final name
+	if err := os.Rename(partPath, ipdbPath); err != nil {
+		return fmt.Errorf("failed to move database file: %w", err)
+	}
+
+	return nil
+}
+
+// writeResumeInfo records next to the partial file at partPath the URL it
+// is downloaded from and the validator of the response, with which it can
+// be resumed; without a usable validator nothing is recorded, so the
+// partial file is not resumed
+func writeResumeInfo(partPath, url string, header http.Header) error {
+	resumePath := partPath + resumeSuffix
+	os.Remove(resumePath)
+
+	// Weak ETags can't be used with If-Range
+	validator := header.Get("ETag")
+	if validator == "" || strings.HasPrefix(validator, "W/") {
+		validator = header.Get("Last-Modified")
+	}
+	if validator == "" {
+		return nil
+	}
+	if err := os.WriteFile(resumePath, []byte(url+"\n"+validator+"\n"), 0644); err != nil {
+		return fmt.Errorf("failed to create temp file: %w", err)
+	}
+	return nil
+}
+
+// readResumeInfo returns the validator to resume the partial file at
+// partPath with, if it was downloaded from url
+func readResumeInfo(partPath, url string) (string, bool) {
+	data, err := os.ReadFile(partPath + resumeSuffix)
+	if err != nil {
+		return "", false
+	}
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	if len(lines) != 2 || lines[0] != url || lines[1] == "" {
+		return "", false
+	}
+	return lines[1], true
+}
+
+// removePartial removes the partial file at partPath and its resume info
+func removePartial(partPath string) {
+	os.Remove(partPath)
+	os.Remove(partPath + resumeSuffix)
+}
+
+// gzipFile compresses the file at path in place, unless the server
+// sent it compressed already
+func gzipFile(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to compress database: %w", err)
+	}
+	defer src.Close()
+
+	magic := make([]byte, 2)
+	if _, err := io.ReadFull(src, magic); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		return nil
+	}
+	if _, err := src.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to compress database: %w", err)
+	}
+
+	tmpPath := path + enrich.GzipExt
+	dst, err := os.Create(tmpPath)
+	if err != nil {
+		return fmt.Errorf("failed to compress database: %w", err)
+	}
+	zw := gzip.NewWriter(dst)
+	_, err = io.Copy(zw, src)
+	if closeErr := zw.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to compress database: %w", err)
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// fetchChecksum downloads a sidecar checksum file in sha256sum format
+func fetchChecksum(url string) (string, error) {
+	resp, err := httpClient.Get(url)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+
+	// Read "<hex digest>  <file name>", only the digest matters
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	if err != nil {
+		return "", err
+	}
+	fields := strings.Fields(string(body))
+	if len(fields) == 0 {
+		return "", fmt.Errorf("empty checksum file")
+	}
+	checksum := strings.ToLower(fields[0])
+	if !validSHA256(checksum) {
+		return "", fmt.Errorf("malformed checksum: %q", fields[0])
+	}
+
+	return checksum, nil
+}
+
+// validSHA256 reports whether checksum is a hex SHA-256 digest
+func validSHA256(checksum string) bool {
+	_, err := hex.DecodeString(checksum)
+	return err == nil && len(checksum) == sha256.Size*2
+}
+
+// fileSHA256 computes the hex SHA-256 digest of a file
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// verifyDownload checks a downloaded file against the expected checksum,
+// or if none is given the one at checksumURL; without either, as mirrors
+// publish no checksum, it warns and only checks that the file is an IP
+// database that loads
+func verifyDownload(path, checksumURL, expected string) error {
+	if expected == "" {
+		checksum, err := fetchChecksum(checksumURL)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: checksum unavailable (%v), checking the downloaded IP database loads instead; pass --db-sha256 to verify it\n", err)
+			if err := enrich.CheckQQWry(path); err != nil {
+				return fmt.Errorf("failed to verify downloaded IP database: %w", err)
+			}
+			return nil
+		}
+		expected = checksum
+	}
+
+	actual, err := fileSHA256(path)
+	if err != nil {
+		return fmt.Errorf("failed to hash downloaded file: %w", err)
+	}
+	if actual != expected {
+		return fmt.Errorf("checksum mismatch for downloaded IP database: expected %s, got %s", expected, actual)
+	}
+
+	return nil
+}
+
+// runDownload implements "ip-plus download [--force]": it fetches and
+// verifies the database like ensureIPDB would before enriching, then stops,
+// so provisioning steps can prepare it ahead of time; --force downloads
+// even if an up-to-date file is already in place
+func (d *downloader) runDownload(args []string, ipdbPath string, maxAge time.Duration) error {
+	flags := flag.NewFlagSet("download", flag.ContinueOnError)
+	force := flags.Bool("force", false, "download even if the database already exists")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s [options] download [--force]\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() > 0 {
+		return fmt.Errorf("unexpected arguments to download: %s", strings.Join(flags.Args(), " "))
+	}
+
+	if *force {
+		return d.downloadIPDB(ipdbPath)
+	}
+	if err := d.ensureIPDB(ipdbPath, maxAge); err != nil {
+		return err
+	}
+	d.infof("IP database is ready at %s\n", ipdbPath)
+	return nil
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// tinyDat returns a qqwry.dat with a single record, small enough to build
+// in a test yet passing the format check
+func tinyDat() []byte {
+	data := make([]byte, 8, 23)
+	binary.LittleEndian.PutUint32(data[0:], 8) // first index entry
+	binary.LittleEndian.PutUint32(data[4:], 8) // last index entry
+	data = append(data, 0, 0, 0, 0, 15, 0, 0)  // start IP, record offset
+	data = append(data, 255, 255, 255, 255)    // end IP
+	return append(data, "X\x00Y\x00"...)
+}
+
+// writeTemp writes data to a file in a test directory and returns its path
+func writeTemp(t *testing.T, data []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "qqwry.ipdb.part")
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestVerifyDownload(t *testing.T) {
+	sum := sha256.Sum256(tinyDat())
+	digest := hex.EncodeToString(sum[:])
+	other := strings.Repeat("0", len(digest))
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/match.sha256":
+			w.Write([]byte(digest + "  qqwry.ipdb\n"))
+		case "/mismatch.sha256":
+			w.Write([]byte(other + "  qqwry.ipdb\n"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	valid := writeTemp(t, tinyDat())
+	garbage := writeTemp(t, []byte("<html>not found</html>"))
+	for _, tc := range []struct {
+		name, path, sidecar, expected string
+		ok                            bool
+	}{
+		{"missing sidecar", valid, "/missing.sha256", "", true},
+		{"missing sidecar, not a database", garbage, "/missing.sha256", "", false},
+		{"sidecar mismatch", valid, "/mismatch.sha256", "", false},
+		{"sidecar match", valid, "/match.sha256", "", true},
+		{"expected match", valid, "/mismatch.sha256", digest, true},
+		{"expected mismatch", valid, "/match.sha256", other, false},
+	} {
+		err := verifyDownload(tc.path, server.URL+tc.sidecar, tc.expected)
+		if (err == nil) != tc.ok {
+			t.Errorf("%s: verifyDownload = %v, want ok %v", tc.name, err, tc.ok)
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package enrich
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/xiaoqidun/qqwry"
+)
+
+// GeoAnnotator names the location in Options.Annotators; it is no
+// registered Annotator but the Enricher's own label, formatted with its
+// template and locale, so the name is reserved
+const GeoAnnotator = "geo"
+
+// Annotator adds text of its own to the annotation of an IP, e.g. a
+// reputation score from local threat intel
+//
+// loc is nil for special IPs and IPs the database has no entry for.
+// Annotate returns "" to add nothing; it is called once per IP, as
+// lookups are cached, and may be called from several goroutines.
+type Annotator interface {
+	Annotate(ip net.IP, loc *qqwry.Location) string
+}
+
+// AnnotatorFunc adapts a function to the Annotator interface
+type AnnotatorFunc func(ip net.IP, loc *qqwry.Location) string
+
+// Annotate calls f
+func (f AnnotatorFunc) Annotate(ip net.IP, loc *qqwry.Location) string {
+	return f(ip, loc)
+}
+
+var (
+	annotatorsMu sync.RWMutex
+	annotators   = map[string]Annotator{}
+)
+
+// RegisterAnnotator makes an annotator available to Options.Annotators
+// by name, typically from the init function of the package defining it:
+//
+//	func init() {
+//		enrich.RegisterAnnotator("rep", enrich.AnnotatorFunc(reputation))
+//	}
+//
+// It panics if the name is taken, GeoAnnotator included, or a is nil,
+// like database/sql.Register.
+func RegisterAnnotator(name string, a Annotator) {
+	annotatorsMu.Lock()
+	defer annotatorsMu.Unlock()
+
+	if a == nil {
+		panic("enrich: RegisterAnnotator of nil annotator " + name)
+	}
+	if _, taken := annotators[name]; taken || name == GeoAnnotator {
+		panic("enrich: RegisterAnnotator called twice for " + name)
+	}
+	annotators[name] = a
+}
+
+// AnnotatorNames returns the names of the registered annotators and
+// GeoAnnotator, sorted
+func AnnotatorNames() []string {
+	annotatorsMu.RLock()
+	defer annotatorsMu.RUnlock()
+	return annotatorNames()
+}
+
+// annotatorNames is AnnotatorNames for callers holding annotatorsMu
+func annotatorNames() []string {
+	names := make([]string, 0, len(annotators)+1)
+	names = append(names, GeoAnnotator)
+	for name := range annotators {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// namedAnnotator is an entry of Options.Annotators, resolved by New
+type namedAnnotator struct {
+	name      string
+	annotator Annotator // nil for GeoAnnotator, which is the label
+}
+
+// resolveAnnotators looks up the annotators named in order, returning
+// nil when geo alone is asked for, as without any
+func resolveAnnotators(names []string) ([]namedAnnotator, error) {
+	if len(names) == 0 || (len(names) == 1 && names[0] == GeoAnnotator) {
+		return nil, nil
+	}
+
+	annotatorsMu.RLock()
+	defer annotatorsMu.RUnlock()
+
+	resolved := make([]namedAnnotator, 0, len(names))
+	for _, name := range names {
+		a, ok := annotators[name]
+		if !ok && name != GeoAnnotator {
+			return nil, fmt.Errorf("unknown annotator: %s (registered: %s)", name, strings.Join(annotatorNames(), ", "))
+		}
+		resolved = append(resolved, namedAnnotator{name: name, annotator: a})
+	}
+	return resolved, nil
+}
+
+// notes runs the annotators other than geo for ip, returning their
+// texts in the order of e.annotators, "" in the place of geo
+func (e *Enricher) notes(ip string, loc *qqwry.Location) []string {
+	parsed := net.ParseIP(ip)
+	notes := make([]string, len(e.annotators))
+	for i, named := range e.annotators {
+		if named.annotator != nil {
+			notes[i] = strings.TrimSpace(named.annotator.Annotate(parsed, loc))
+		}
+	}
+	return notes
+}
+
+// composeNotes joins the location label with the notes of the other
+// annotators in the configured order, leaving out empty ones; if all are
+// empty, the location label stands
+func (e *Enricher) composeNotes(location string, notes []string) string {
+	parts := make([]string, 0, len(e.annotators))
+	for i, named := range e.annotators {
+		text := notes[i]
+		if named.annotator == nil {
+			text = location
+		}
+		if text != "" {
+			parts = append(parts, text)
+		}
+	}
+	if len(parts) == 0 {
+		return location
+	}
+	return strings.Join(parts, " / ")
+}
+
+// noteMap returns the non-empty notes keyed by annotator name, for JSON
+func (e *Enricher) noteMap(notes []string) map[string]string {
+	var named map[string]string
+	for i, text := range notes {
+		if text == "" || e.annotators[i].annotator == nil {
+			continue
+		}
+		if named == nil {
+			named = make(map[string]string, len(notes))
+		}
+		named[e.annotators[i].name] = text
+	}
+	return named
+}
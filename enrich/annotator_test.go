@@ -0,0 +1,34 @@
+package enrich
+
+import (
+	"net"
+	"testing"
+
+	"github.com/xiaoqidun/qqwry"
+)
+
+func init() {
+	RegisterAnnotator("test-rep", AnnotatorFunc(func(ip net.IP, loc *qqwry.Location) string {
+		if ip.IsPrivate() {
+			return ""
+		}
+		return "rep:bad"
+	}))
+}
+
+func TestAnnotatorsCompose(t *testing.T) {
+	e := newTestEnricher(t, Options{Annotators: []string{"test-rep", GeoAnnotator}})
+	got := e.Enrich("8.8.8.8 10.0.0.1")
+	if want := "8.8.8.8(rep:bad / 加利福尼亚山景城谷歌) 10.0.0.1(Local)"; got != want {
+		t.Errorf("Enrich = %q, want %q", got, want)
+	}
+}
+
+func TestRegisterAnnotatorGeoReserved(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("RegisterAnnotator(GeoAnnotator) did not panic")
+		}
+	}()
+	RegisterAnnotator(GeoAnnotator, AnnotatorFunc(func(net.IP, *qqwry.Location) string { return "" }))
+}
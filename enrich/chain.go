@@ -0,0 +1,58 @@
+package enrich
+
+import (
+	"github.com/xiaoqidun/qqwry"
+)
+
+// Chain consults its backends in order and answers with the first
+// location that names a country, e.g. qqwry followed by MaxMind for what
+// qqwry has no entry for; if none does, the first backend's answer stands
+//
+// qqwry backends keep their data in package-level state, so a chain
+// can't hold two of them.
+type Chain []Backend
+
+// Query looks ip up in each backend in turn
+func (c Chain) Query(ip string) (*qqwry.Location, error) {
+	var first *qqwry.Location
+	var firstErr error
+	for i, backend := range c {
+		loc, err := backend.Query(ip)
+		if err == nil && hasCountry(loc) {
+			return loc, nil
+		}
+		if i == 0 {
+			first, firstErr = loc, err
+		}
+	}
+	return first, firstErr
+}
+
+// QueryCoordinates returns the position of ip from the backend that
+// answered for it, if that backend has coordinates
+func (c Chain) QueryCoordinates(ip string) (Coordinates, bool) {
+	for _, backend := range c {
+		if loc, err := backend.Query(ip); err != nil || !hasCountry(loc) {
+			continue
+		}
+		if coords, ok := backend.(CoordinateBackend); ok {
+			return coords.QueryCoordinates(ip)
+		}
+		return Coordinates{}, false
+	}
+	return Coordinates{}, false
+}
+
+// hasCountry reports whether loc names a country, rather than being
+// empty or a placeholder for unknown addresses
+func hasCountry(loc *qqwry.Location) bool {
+	if loc == nil {
+		return false
+	}
+	switch loc.Country {
+	case "", "0", "未知", "Unknown":
+		return false
+	default:
+		return true
+	}
+}
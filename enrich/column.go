@@ -0,0 +1,56 @@
+package enrich
+
+import (
+	"strings"
+)
+
+// tabWidth is the distance between the tab stops lineColumns assumes
+const tabWidth = 8
+
+// annotateColumn leaves the IPs of line in place and appends their
+// annotations, space-padded to start Options.AnnotationColumn columns into
+// the line so they line up across rows; a wider line gets one space
+func (e *Enricher) annotateColumn(line string, results []Result, skipped func(Result) bool) string {
+	var splices []splice
+	annotations := make([]string, 0, len(results))
+	for _, result := range results {
+		if skipped(result) {
+			continue
+		}
+
+		annotation := e.template.Wrap(e.label(result))
+		if e.opts.Color {
+			annotation = result.color + annotation + colorReset
+		}
+		annotations = append(annotations, annotation)
+
+		// Mark the IP itself
+		if e.opts.Color && e.opts.Highlight {
+			splices = append(splices, splice{result.Start, result.End, highlightStart + line[result.Start:result.End] + highlightEnd})
+		}
+	}
+	if len(annotations) == 0 {
+		return line
+	}
+
+	pad := max(e.opts.AnnotationColumn-lineColumns(line), 1)
+	return applySplices(line, splices) + strings.Repeat(" ", pad) + strings.Join(annotations, " ")
+}
+
+// lineColumns returns the terminal column line ends at, skipping ANSI
+// escape sequences and advancing tabs to the next tab stop
+func lineColumns(line string) int {
+	if strings.IndexByte(line, '\x1b') >= 0 {
+		line, _ = stripANSI(line)
+	}
+
+	n := 0
+	for _, r := range line {
+		if r == '\t' {
+			n += tabWidth - n%tabWidth
+			continue
+		}
+		n += runeWidth(r)
+	}
+	return n
+}
@@ -0,0 +1,43 @@
+package enrich
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// Longest time Options.LookupCommand may take for one IP
+const commandTimeout = 5 * time.Second
+
+// errNoCommand is the error of queryCommand without a lookup command
+var errNoCommand = errors.New("no lookup command")
+
+// queryCommand runs Options.LookupCommand for ip and returns the first
+// line of its output; each IP runs it once, as lookups are cached
+func (e *Enricher) queryCommand(ip string) (string, error) {
+	command := e.opts.LookupCommand
+	if len(command) == 0 {
+		return "", errNoCommand
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), commandTimeout)
+	defer cancel()
+
+	args := append(append([]string(nil), command[1:]...), ip)
+	output, err := exec.CommandContext(ctx, command[0], args...).Output()
+	if err != nil {
+		err = fmt.Errorf("lookup command failed: %w", err)
+		e.opts.Logger.Debug("falling back to the database", "ip", ip, "err", err)
+		return "", err
+	}
+
+	label, _, _ := strings.Cut(string(output), "\n")
+	label = strings.TrimSpace(label)
+	if label == "" {
+		return "", ErrNotFound
+	}
+	return label, nil
+}
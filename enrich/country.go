@@ -0,0 +1,124 @@
+package enrich
+
+import "strings"
+
+// country describes a country by ISO 3166-1 alpha-2 code and its names
+type country struct {
+	code string
+	zh   string // name as used by qqwry and MaxMind zh-CN
+	en   string // English name
+}
+
+// countries lists the countries we know names for
+var countries = []country{
+	{"CN", "中国", "China"},
+	{"HK", "香港", "Hong Kong"},
+	{"MO", "澳门", "Macau"},
+	{"TW", "台湾", "Taiwan"},
+	{"US", "美国", "United States"},
+	{"CA", "加拿大", "Canada"},
+	{"MX", "墨西哥", "Mexico"},
+	{"BR", "巴西", "Brazil"},
+	{"AR", "阿根廷", "Argentina"},
+	{"CL", "智利", "Chile"},
+	{"CO", "哥伦比亚", "Colombia"},
+	{"PE", "秘鲁", "Peru"},
+	{"GB", "英国", "United Kingdom"},
+	{"IE", "爱尔兰", "Ireland"},
+	{"FR", "法国", "France"},
+	{"DE", "德国", "Germany"},
+	{"NL", "荷兰", "Netherlands"},
+	{"BE", "比利时", "Belgium"},
+	{"LU", "卢森堡", "Luxembourg"},
+	{"CH", "瑞士", "Switzerland"},
+	{"AT", "奥地利", "Austria"},
+	{"IT", "意大利", "Italy"},
+	{"ES", "西班牙", "Spain"},
+	{"PT", "葡萄牙", "Portugal"},
+	{"SE", "瑞典", "Sweden"},
+	{"NO", "挪威", "Norway"},
+	{"DK", "丹麦", "Denmark"},
+	{"FI", "芬兰", "Finland"},
+	{"IS", "冰岛", "Iceland"},
+	{"PL", "波兰", "Poland"},
+	{"CZ", "捷克", "Czechia"},
+	{"SK", "斯洛伐克", "Slovakia"},
+	{"HU", "匈牙利", "Hungary"},
+	{"RO", "罗马尼亚", "Romania"},
+	{"BG", "保加利亚", "Bulgaria"},
+	{"GR", "希腊", "Greece"},
+	{"UA", "乌克兰", "Ukraine"},
+	{"BY", "白俄罗斯", "Belarus"},
+	{"RU", "俄罗斯", "Russia"},
+	{"TR", "土耳其", "Turkey"},
+	{"IL", "以色列", "Israel"},
+	{"SA", "沙特阿拉伯", "Saudi Arabia"},
+	{"AE", "阿联酋", "United Arab Emirates"},
+	{"IR", "伊朗", "Iran"},
+	{"IQ", "伊拉克", "Iraq"},
+	{"EG", "埃及", "Egypt"},
+	{"ZA", "南非", "South Africa"},
+	{"NG", "尼日利亚", "Nigeria"},
+	{"KE", "肯尼亚", "Kenya"},
+	{"IN", "印度", "India"},
+	{"PK", "巴基斯坦", "Pakistan"},
+	{"BD", "孟加拉", "Bangladesh"},
+	{"JP", "日本", "Japan"},
+	{"KR", "韩国", "South Korea"},
+	{"KP", "朝鲜", "North Korea"},
+	{"MN", "蒙古", "Mongolia"},
+	{"KZ", "哈萨克斯坦", "Kazakhstan"},
+	{"VN", "越南", "Vietnam"},
+	{"TH", "泰国", "Thailand"},
+	{"MY", "马来西亚", "Malaysia"},
+	{"SG", "新加坡", "Singapore"},
+	{"ID", "印度尼西亚", "Indonesia"},
+	{"PH", "菲律宾", "Philippines"},
+	{"KH", "柬埔寨", "Cambodia"},
+	{"MM", "缅甸", "Myanmar"},
+	{"LA", "老挝", "Laos"},
+	{"NP", "尼泊尔", "Nepal"},
+	{"LK", "斯里兰卡", "Sri Lanka"},
+	{"AU", "澳大利亚", "Australia"},
+	{"NZ", "新西兰", "New Zealand"},
+}
+
+// countryCodes maps lowercase country names and codes to ISO codes
+var countryCodes = map[string]string{}
+
+func init() {
+	for _, c := range countries {
+		countryCodes[c.zh] = c.code
+		countryCodes[strings.ToLower(c.en)] = c.code
+		countryCodes[strings.ToLower(c.code)] = c.code
+	}
+	// Alternate names seen in databases
+	countryCodes["美利坚合众国"] = "US"
+	countryCodes["usa"] = "US"
+	countryCodes["uk"] = "GB"
+	countryCodes["russian federation"] = "RU"
+	countryCodes["republic of korea"] = "KR"
+}
+
+// CountryCode returns the ISO 3166-1 alpha-2 code of a country name
+// (Chinese or English) or code, or "" if it isn't known
+func CountryCode(name string) string {
+	return countryCodes[strings.ToLower(strings.TrimSpace(name))]
+}
+
+// FlagEmoji returns the regional indicator flag of an ISO country code,
+// or "" if code isn't two ASCII letters
+func FlagEmoji(code string) string {
+	if len(code) != 2 {
+		return ""
+	}
+
+	flag := make([]rune, 0, 2)
+	for _, c := range strings.ToUpper(code) {
+		if c < 'A' || c > 'Z' {
+			return ""
+		}
+		flag = append(flag, 0x1F1E6+c-'A')
+	}
+	return string(flag)
+}
@@ -0,0 +1,49 @@
+package enrich
+
+import (
+	"encoding/csv"
+	"strings"
+)
+
+// ResolveCSVLine resolves the IPs in one column (1-based) of a CSV line,
+// at positions within that field rather than the line, so IPs in other
+// columns are left out
+//
+// It returns false if line doesn't parse as a single CSV record.
+func (e *Enricher) ResolveCSVLine(line string, column int, comma rune) ([]Result, bool) {
+	reader := csv.NewReader(strings.NewReader(line))
+	reader.Comma = comma
+	reader.FieldsPerRecord = -1
+	record, err := reader.Read()
+	if err != nil || reader.InputOffset() < int64(len(line)) {
+		return nil, false
+	}
+
+	if column < 1 || column > len(record) {
+		return []Result{}, true
+	}
+	return e.Resolve(record[column-1]), true
+}
+
+// RenderCSVLine appends the locations of results from ResolveCSVLine to
+// line as a new last column, leaving the original fields byte for byte as
+// they were, so the line stays valid CSV
+//
+// The new column is empty where the column holds no IP worth annotating.
+func (e *Enricher) RenderCSVLine(line string, results []Result, comma rune) string {
+	var labels []string
+	for _, result := range results {
+		if e.opts.ForeignOnly && !result.IsForeign() {
+			continue
+		}
+		labels = append(labels, e.label(result))
+	}
+
+	// Let the CSV writer decide whether the label needs quotes
+	var field strings.Builder
+	writer := csv.NewWriter(&field)
+	writer.Comma = comma
+	writer.Write([]string{strings.Join(labels, ", ")})
+	writer.Flush()
+	return line + string(comma) + strings.TrimSuffix(field.String(), "\n")
+}
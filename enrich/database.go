@@ -0,0 +1,222 @@
+package enrich
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ipipdotnet/ipdb-go"
+	"github.com/xiaoqidun/qqwry"
+)
+
+// Backend looks up the location of IP addresses in some database
+type Backend interface {
+	// Query returns the location of ip, or an error if it can't be found
+	Query(ip string) (*qqwry.Location, error)
+}
+
+// ErrNotFound is returned by backends for IPs the database has no entry for
+var ErrNotFound = errors.New("ip not found")
+
+// Backend names accepted by Open
+const (
+	BackendQQWry     = "qqwry"
+	BackendMaxMind   = "maxmind"
+	BackendIP2Region = "ip2region"
+)
+
+// GzipExt marks a gzip-compressed database file, e.g. "qqwry.ipdb.gz"
+const GzipExt = ".gz"
+
+// DatabaseExt returns the lower-case extension of a database file, looking
+// past GzipExt, e.g. ".ipdb" for "qqwry.ipdb.gz"
+func DatabaseExt(path string) string {
+	ext := strings.ToLower(filepath.Ext(path))
+	if ext == GzipExt {
+		ext = strings.ToLower(filepath.Ext(path[:len(path)-len(GzipExt)]))
+	}
+	return ext
+}
+
+// readDatabase reads the database file at path into memory,
+// decompressing it if it is gzipped, whatever its name
+func readDatabase(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil || !isGzip(data) {
+		return data, err
+	}
+
+	reader, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress %s: %w", path, err)
+	}
+	defer reader.Close()
+	data, err = io.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress %s: %w", path, err)
+	}
+	return data, nil
+}
+
+// isGzip reports whether data starts with the gzip magic number
+func isGzip(data []byte) bool {
+	return len(data) >= 2 && data[0] == 0x1f && data[1] == 0x8b
+}
+
+// DetectBackend guesses the backend name from a database file extension
+func DetectBackend(path string) string {
+	switch DatabaseExt(path) {
+	case ".mmdb":
+		return BackendMaxMind
+	case ".xdb":
+		return BackendIP2Region
+	default:
+		return BackendQQWry
+	}
+}
+
+// Open loads the database at path with the named backend,
+// detecting it from the file extension if backend is empty
+func Open(path, backend string) (Backend, error) {
+	if backend == "" {
+		backend = DetectBackend(path)
+	}
+
+	switch backend {
+	case BackendQQWry:
+		return OpenQQWry(path)
+	case BackendMaxMind:
+		return OpenMaxMind(path)
+	case BackendIP2Region:
+		return OpenIP2Region(path)
+	default:
+		return nil, fmt.Errorf("unknown backend: %s", backend)
+	}
+}
+
+// File formats read by the qqwry backend
+const (
+	QQWryFormatIPDB = "ipdb" // ipip.net format of the current qqwry releases
+	QQWryFormatDat  = "dat"  // classic CZ88 qqwry.dat
+)
+
+// QQWry is a loaded qqwry IP database
+//
+// The qqwry library keeps its data in package-level state, so only
+// the most recently opened QQWry is effective.
+type QQWry struct {
+	path   string
+	format string
+	info   Info // metadata read from the file header
+}
+
+// OpenQQWry loads the qqwry IP database at path, either an .ipdb
+// or a legacy .dat file, telling them apart by their content; either
+// may be gzipped
+func OpenQQWry(path string) (db *QQWry, err error) {
+	data, err := readDatabase(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load IP database: %w", err)
+	}
+	format, err := detectQQWryFormat(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load IP database %s: %w", path, err)
+	}
+
+	// The library panics on corrupt ipdb files instead of failing
+	defer func() {
+		if r := recover(); r != nil {
+			db, err = nil, fmt.Errorf("failed to load IP database %s: %v", path, r)
+		}
+	}()
+	qqwry.LoadData(data)
+
+	return &QQWry{path: path, format: format, info: qqwryInfo(data, format)}, nil
+}
+
+// CheckQQWry reports whether the file at path is a qqwry database
+// OpenQQWry would load, without loading it in its place
+func CheckQQWry(path string) (err error) {
+	data, err := readDatabase(path)
+	if err != nil {
+		return fmt.Errorf("failed to read IP database: %w", err)
+	}
+	format, err := detectQQWryFormat(data)
+	if err != nil {
+		return fmt.Errorf("invalid IP database %s: %w", path, err)
+	}
+	if format != QQWryFormatIPDB {
+		return nil
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("invalid IP database %s: %v", path, r)
+		}
+	}()
+	if _, err := ipdb.NewCityFromBytes(data); err != nil {
+		return fmt.Errorf("invalid IP database %s: %w", path, err)
+	}
+	return nil
+}
+
+// detectQQWryFormat tells an ipdb file from a qqwry.dat by its header
+//
+// A .dat starts with the little-endian offsets of the first and last
+// 7-byte index entries, which must lie within the file; checking them
+// keeps the library from searching garbage forever.
+func detectQQWryFormat(data []byte) (string, error) {
+	if len(data) >= 11 && string(data[6:11]) == "build" {
+		return QQWryFormatIPDB, nil
+	}
+	if len(data) < 8 {
+		return "", errors.New("file too short")
+	}
+
+	first := binary.LittleEndian.Uint32(data[:4])
+	last := binary.LittleEndian.Uint32(data[4:8])
+	if first < 8 || last < first || uint64(last)+7 > uint64(len(data)) || (last-first)%7 != 0 {
+		return "", errors.New("neither an ipdb nor a qqwry.dat file")
+	}
+	return QQWryFormatDat, nil
+}
+
+// Format returns the file format, QQWryFormatIPDB or QQWryFormatDat
+func (d *QQWry) Format() string {
+	return d.format
+}
+
+// Path returns the file the database was loaded from
+func (d *QQWry) Path() string {
+	return d.path
+}
+
+// Query looks up the location of an IP address
+func (d *QQWry) Query(ip string) (*qqwry.Location, error) {
+	loc, err := qqwry.QueryIP(ip)
+	if err != nil && qqwryMiss(err) {
+		return nil, ErrNotFound
+	}
+	return loc, err
+}
+
+// qqwryMiss reports whether err from qqwry.QueryIP means the database
+// has no entry for the IP, including IPv6 in an IPv4-only database
+func qqwryMiss(err error) bool {
+	if errors.Is(err, ipdb.ErrDataNotExists) || errors.Is(err, ipdb.ErrNoSupportIPv6) {
+		return true
+	}
+
+	// The .dat reader has no error values to compare against
+	switch err.Error() {
+	case "ip not found", "ip is not ipv4":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,131 @@
+package enrich
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/xiaoqidun/qqwry"
+)
+
+// Mean radius of the earth in kilometers
+const earthRadius = 6371.0
+
+// Coordinates is a point on the earth in decimal degrees
+type Coordinates struct {
+	Lat, Lon float64
+}
+
+// ParseCoordinates parses "lat,lon" in decimal degrees, e.g. "39.9,116.4"
+func ParseCoordinates(text string) (Coordinates, error) {
+	latText, lonText, ok := strings.Cut(text, ",")
+	if !ok {
+		return Coordinates{}, fmt.Errorf("invalid coordinates: %s (expected lat,lon)", text)
+	}
+	lat, err := strconv.ParseFloat(strings.TrimSpace(latText), 64)
+	if err != nil || lat < -90 || lat > 90 {
+		return Coordinates{}, fmt.Errorf("invalid latitude: %s", latText)
+	}
+	lon, err := strconv.ParseFloat(strings.TrimSpace(lonText), 64)
+	if err != nil || lon < -180 || lon > 180 {
+		return Coordinates{}, fmt.Errorf("invalid longitude: %s", lonText)
+	}
+	return Coordinates{Lat: lat, Lon: lon}, nil
+}
+
+// CoordinateBackend is implemented by backends whose records carry
+// the coordinates of an IP, used for Options.DistanceFrom
+type CoordinateBackend interface {
+	// QueryCoordinates returns the approximate position of ip
+	QueryCoordinates(ip string) (Coordinates, bool)
+}
+
+// provinceCoords locates Chinese provinces and regions, keyed like
+// provinceNames, at their capitals; it stands in for the coordinates
+// the qqwry databases lack
+var provinceCoords = map[string]Coordinates{
+	"北京":  {39.90, 116.40},
+	"天津":  {39.13, 117.20},
+	"上海":  {31.23, 121.47},
+	"重庆":  {29.56, 106.55},
+	"河北":  {38.04, 114.51},
+	"山西":  {37.87, 112.55},
+	"辽宁":  {41.80, 123.43},
+	"吉林":  {43.82, 125.32},
+	"黑龙江": {45.80, 126.53},
+	"江苏":  {32.06, 118.80},
+	"浙江":  {30.27, 120.16},
+	"安徽":  {31.82, 117.23},
+	"福建":  {26.07, 119.30},
+	"江西":  {28.68, 115.86},
+	"山东":  {36.65, 117.12},
+	"河南":  {34.75, 113.63},
+	"湖北":  {30.59, 114.31},
+	"湖南":  {28.23, 112.94},
+	"广东":  {23.13, 113.26},
+	"海南":  {20.04, 110.20},
+	"四川":  {30.57, 104.07},
+	"贵州":  {26.65, 106.63},
+	"云南":  {25.04, 102.71},
+	"陕西":  {34.34, 108.94},
+	"甘肃":  {36.06, 103.83},
+	"青海":  {36.62, 101.78},
+	"台湾":  {25.03, 121.57},
+	"内蒙古": {40.84, 111.75},
+	"广西":  {22.82, 108.37},
+	"西藏":  {29.65, 91.14},
+	"宁夏":  {38.49, 106.23},
+	"新疆":  {43.83, 87.62},
+	"香港":  {22.32, 114.17},
+	"澳门":  {22.20, 113.54},
+}
+
+// coordinates returns the position of ip from the database if it has
+// them, else from the province of loc
+func (e *Enricher) coordinates(ip string, loc *qqwry.Location) (Coordinates, bool) {
+	if backend, ok := e.db.(CoordinateBackend); ok {
+		if coords, ok := backend.QueryCoordinates(ip); ok {
+			return coords, true
+		}
+	}
+	coords, ok := provinceCoords[shortProvince(loc.Province)]
+	return coords, ok
+}
+
+// distanceText describes how far and in which direction to is from
+// from, e.g. "1068km NW"
+func distanceText(from, to Coordinates) string {
+	return fmt.Sprintf("%.0fkm %s", haversine(from, to), compassPoint(bearing(from, to)))
+}
+
+// haversine returns the great-circle distance between a and b in kilometers
+func haversine(a, b Coordinates) float64 {
+	lat1, lat2 := radians(a.Lat), radians(b.Lat)
+	dLat, dLon := lat2-lat1, radians(b.Lon-a.Lon)
+
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadius * math.Asin(math.Sqrt(h))
+}
+
+// bearing returns the initial compass bearing from a to b in degrees
+func bearing(a, b Coordinates) float64 {
+	lat1, lat2 := radians(a.Lat), radians(b.Lat)
+	dLon := radians(b.Lon - a.Lon)
+
+	y := math.Sin(dLon) * math.Cos(lat2)
+	x := math.Cos(lat1)*math.Sin(lat2) - math.Sin(lat1)*math.Cos(lat2)*math.Cos(dLon)
+	return math.Mod(math.Atan2(y, x)*180/math.Pi+360, 360)
+}
+
+// compassPoint names the nearest of the eight principal compass points
+func compassPoint(degrees float64) string {
+	points := [...]string{"N", "NE", "E", "SE", "S", "SW", "W", "NW"}
+	return points[int((degrees+22.5)/45)%len(points)]
+}
+
+// radians converts degrees to radians
+func radians(degrees float64) float64 {
+	return degrees * math.Pi / 180
+}
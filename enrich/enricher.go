@@ -0,0 +1,786 @@
+// Package enrich annotates IP addresses found in text with their geographic locations.
+package enrich
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/xiaoqidun/qqwry"
+)
+
+// Default time to wait for a reverse DNS answer
+const defaultRDNSTimeout = 500 * time.Millisecond
+
+// Placements accepted by Options.Placement
+const (
+	PlacementAfter  = "after"  // "8.8.8.8(美国)", the default
+	PlacementBefore = "before" // "(美国) 8.8.8.8"
+)
+
+// Options controls how an Enricher renders annotations
+type Options struct {
+	Color  bool   // wrap annotations in ANSI color codes
+	Format string // annotation template, DefaultFormat if empty
+
+	// AfterPort places the annotation after a trailing ":port",
+	// e.g. "192.168.1.5:22 (Local)" instead of "192.168.1.5(Local):22"
+	AfterPort bool
+
+	// Placement puts annotations after the IP (PlacementAfter, the
+	// default) or before it, separated by a space (PlacementBefore)
+	Placement string
+
+	// DedupLine annotates only the first occurrence of an IP within a line
+	DedupLine bool
+
+	// ForeignOnly skips annotating domestic and special IPs
+	ForeignOnly bool
+
+	// RDNS adds the first PTR name of non-special IPs to the annotation,
+	// waiting at most RDNSTimeout (default 500ms) per lookup
+	RDNS        bool
+	RDNSTimeout time.Duration
+
+	// LookupTimeout bounds the wait for any one IP's lookup, reverse DNS
+	// and lookup command included; a slower IP is labeled "Timeout" while
+	// its lookup finishes in the background for later lines. 0 waits
+	LookupTimeout time.Duration
+
+	// ShowISP appends the ISP/operator to the location when the
+	// template doesn't already include {isp}
+	ShowISP bool
+
+	// Flag prefixes the location with the country's flag emoji
+	Flag bool
+
+	// SkipURLs leaves IPs that are the host of a URL unannotated,
+	// so "http://1.2.3.4/path" stays intact
+	SkipURLs bool
+
+	// SkipTimestamps leaves IPv4 matches that look like part of a
+	// timestamp unannotated, e.g. "10.24.15.30" after "2024-10-24 "
+	SkipTimestamps bool
+
+	// ASN adds the autonomous system of non-special IPs to the annotation
+	ASN ASNBackend
+
+	// Highlight marks annotated IPs in bold and underline; needs Color
+	Highlight bool
+
+	// Replace substitutes matched IPs with their location label instead of
+	// annotating them; IPs of unknown location are left as they are
+	Replace bool
+
+	// PadWidth pads annotations with spaces to this many terminal columns,
+	// counting CJK characters as two, so annotated columns line up
+	PadWidth int
+
+	// MaxLocWidth cuts locations longer than this many terminal columns
+	// short with an ellipsis, e.g. long ISP descriptions; 0 for no limit
+	MaxLocWidth int
+
+	// Annotators names registered annotators whose texts make up each
+	// annotation, in order and joined by " / "; GeoAnnotator stands for
+	// the location. Empty means the location alone
+	Annotators []string
+
+	// AnnotationColumn leaves IPs as they are and appends the annotations
+	// of a line at this display column instead, aligning them across
+	// lines; 0 annotates next to each IP
+	AnnotationColumn int
+
+	// HomeCountry is the country treated as domestic by ForeignOnly and the
+	// annotation colors, as the database names it or as an ISO code;
+	// defaults to China
+	HomeCountry string
+
+	// ErrorLabels tells IPs missing from the database ("NotFound") apart
+	// from failed lookups ("LookupError") instead of labeling both "Unknown";
+	// Verbose implies it and appends the error text to "LookupError"
+	ErrorLabels bool
+	Verbose     bool
+
+	// FirstIPOnly resolves only the leftmost IP of each line
+	FirstIPOnly bool
+
+	// DecodeIntIPs also matches IPv4 addresses written as a 32-bit integer,
+	// e.g. "3232235521" or "0xC0A80001", annotating them with the address
+	DecodeIntIPs bool
+
+	// LeadingZeros also matches IPv4 addresses with zero-padded octets,
+	// e.g. "192.168.001.001", which are looked up as "192.168.1.1" while
+	// the line keeps showing them as written
+	LeadingZeros bool
+
+	// SkipNets leaves IPs in these networks untouched; if OnlyNets is
+	// set, IPs outside all of its networks are left untouched as well
+	SkipNets []*net.IPNet
+	OnlyNets []*net.IPNet
+
+	// Separator goes between adjacent placeholders of Format that have
+	// no text between them, e.g. "/" turns 中国北京 into 中国/北京
+	Separator string
+
+	// DedupFields drops a location field equal to the one before it,
+	// so a province and city of 北京 show up once
+	DedupFields bool
+
+	// Warnings receives the first database failure (a panic or a missing
+	// database), which degrades lookups to "Unknown"; nil discards it
+	Warnings io.Writer
+
+	// Locale translates country and province names, LocaleEnglish for
+	// English; the fields are then separated by a space unless
+	// Separator says otherwise
+	Locale string
+
+	// LookupCommand is run with each IP appended as the last argument,
+	// its first line of output becoming the label; the first word is
+	// taken as the country for ForeignOnly and colors. IPs are resolved
+	// with the database when the command fails or prints nothing.
+	LookupCommand []string
+
+	// NoSpecial looks up private, loopback and reserved IPs in the
+	// database too, instead of labeling them Local or Reserved
+	NoSpecial bool
+
+	// Logger receives debug records of failed lookups; nil discards them
+	Logger *slog.Logger
+
+	// GroupLists annotates comma or space separated lists of IPs once,
+	// after the list, e.g. "1.1.1.1, 8.8.8.8 [APNIC, GOOGLE.COM]"
+	GroupLists bool
+
+	// DistanceFrom adds the distance and compass direction from these
+	// coordinates to annotations, e.g. "(北京, 1068km NW)"; positions come
+	// from backends implementing CoordinateBackend, or else from the
+	// province, so only Chinese provinces are placed with qqwry
+	DistanceFrom *Coordinates
+
+	// FirstSeenOnly annotates each IP only the first time Render shows
+	// it, leaving later appearances in the same run bare
+	FirstSeenOnly bool
+
+	// Overrides label the IPs and networks it lists before any other
+	// lookup, the most specific network winning; nil for none
+	Overrides *Overrides
+
+	// MaxIPsPerLine resolves at most this many IPs of each line, leftmost
+	// first, bounding the work a line packed with IPs can cause; 0 for
+	// no limit
+	MaxIPsPerLine int
+
+	// Passthrough resolves nothing, leaving every line as it is, e.g.
+	// when no database could be loaded
+	Passthrough bool
+
+	// ShowTimeZone adds the current UTC offset of the country to
+	// annotations, e.g. "(北京, UTC+8)"; countries spanning several zones
+	// get their capital's offset marked with "~", e.g. "~UTC-4"
+	ShowTimeZone bool
+}
+
+// Enricher annotates IP addresses in text using a loaded database
+type Enricher struct {
+	db         Backend
+	opts       Options
+	template   *Template
+	homeCode   string           // ISO code of the home country, if known
+	cache      sync.Map         // normalized IP -> Lookup
+	seen       sync.Map         // normalized IPs annotated so far, for FirstSeenOnly
+	annotators []namedAnnotator // resolved Options.Annotators, nil for geo alone
+	pending    sync.Map         // normalized IP -> *pendingLookup, for LookupTimeout
+
+	// Lookups answered from the cache and from the database
+	hits   atomic.Uint64
+	misses atomic.Uint64
+
+	warnOnce sync.Once // reports only the first database failure
+}
+
+// New creates an Enricher that resolves IPs with db
+func New(db Backend, opts Options) (*Enricher, error) {
+	if opts.Format == "" {
+		opts.Format = DefaultFormat
+	}
+	template, err := ParseTemplate(opts.Format)
+	if err != nil {
+		return nil, err
+	}
+	if err := validLocale(opts.Locale); err != nil {
+		return nil, err
+	}
+	switch opts.Placement {
+	case "", PlacementAfter, PlacementBefore:
+	default:
+		return nil, fmt.Errorf("unknown placement: %s (expected before or after)", opts.Placement)
+	}
+	if opts.Locale == LocaleEnglish && opts.Separator == "" {
+		opts.Separator = " "
+	}
+	template.sep, template.dedup = opts.Separator, opts.DedupFields
+
+	if opts.Logger == nil {
+		opts.Logger = slog.New(slog.DiscardHandler)
+	}
+
+	if opts.HomeCountry == "" {
+		opts.HomeCountry = defaultHomeCountry
+	}
+	homeCode := CountryCode(opts.HomeCountry)
+	if homeCode == "" && FlagEmoji(opts.HomeCountry) != "" {
+		// An ISO code we have no names for
+		homeCode = strings.ToUpper(opts.HomeCountry)
+	}
+
+	annotators, err := resolveAnnotators(opts.Annotators)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Enricher{db: db, opts: opts, template: template, homeCode: homeCode, annotators: annotators}, nil
+}
+
+// isHome reports whether a country name from the database is the home country
+func (e *Enricher) isHome(country string) bool {
+	if country == e.opts.HomeCountry {
+		return true
+	}
+	return e.homeCode != "" && CountryCode(country) == e.homeCode
+}
+
+// Lookup stores the resolved location of an IP address
+type Lookup struct {
+	Special  bool            // loopback, private, reserved, etc.
+	Location *qqwry.Location // nil if special or not found
+	Label    string          // formatted location text
+	Hostname string          // reverse DNS name, if enabled and found
+	ASN      uint            // autonomous system number, if enabled and found
+	ASOrg    string          // autonomous system organization
+	TimeZone string          // UTC offset of the country, if enabled and known
+	Distance string          // distance and direction from Options.DistanceFrom, if known
+	notes    []string        // texts of Options.Annotators, see Enricher.notes
+	foreign  bool            // neither special nor in the home country
+	color    string          // annotation color
+}
+
+// Result stores a matched IP together with its resolved location
+type Result struct {
+	Match
+	Lookup
+}
+
+// resolved reports whether the IP is special or has a known location
+func (l Lookup) resolved() bool {
+	return l.Special || (l.Location != nil && l.Label != "Unknown")
+}
+
+// IsForeign reports whether the IP is neither special nor located in the home country
+func (l Lookup) IsForeign() bool {
+	return l.foreign
+}
+
+// normalizeIP returns the canonical string form of an IP, used as cache key
+func normalizeIP(ip string) string {
+	ip = strings.Trim(ip, "[]")
+	if parsedIP := net.ParseIP(ip); parsedIP != nil {
+		return parsedIP.String()
+	}
+	return ip
+}
+
+// lookup resolves the location of an IP, consulting the cache first
+func (e *Enricher) lookup(ip string) Lookup {
+	key := normalizeIP(ip)
+	if cached, ok := e.cache.Load(key); ok {
+		e.hits.Add(1)
+		return cached.(Lookup)
+	}
+	e.misses.Add(1)
+
+	result := e.locate(ip, true)
+	e.cache.Store(key, result)
+	return result
+}
+
+// stripLookup returns the lookup of ip Strip checks labels against: the
+// cached one, or else one from the overrides and the database alone,
+// which runs no lookup command or DNS query and isn't cached
+func (e *Enricher) stripLookup(ip string) Lookup {
+	if cached, ok := e.cache.Load(normalizeIP(ip)); ok {
+		return cached.(Lookup)
+	}
+	return e.locate(ip, false)
+}
+
+// locate resolves the location of an IP without the cache; external
+// also runs the lookup command, reverse DNS, ASN and annotators
+func (e *Enricher) locate(ip string, external bool) Lookup {
+	key := normalizeIP(ip)
+	command := e.queryCommand
+	if !external {
+		command = func(string) (string, error) { return "", errNoCommand }
+	}
+
+	result := Lookup{Label: "Unknown", foreign: true, color: colorGray}
+	if label, ok := e.opts.Overrides.Lookup(ip); ok {
+		// Custom names of known hosts, domestic by definition
+		result.foreign = false
+		result.Label = label
+		result.color = locationColor(true)
+	} else if !e.opts.NoSpecial && IsSpecialIP(ip) {
+		result.Special, result.foreign = true, false
+		result.Label = "Local"
+	} else if !e.opts.NoSpecial && IsReservedIP(ip) {
+		result.Special, result.foreign = true, false
+		result.Label = "Reserved"
+	} else if label, err := command(ip); err == nil {
+		result.foreign = !e.isHome(strings.Fields(label)[0])
+		result.Label = label
+		result.color = locationColor(!result.foreign)
+	} else if loc, err := e.query(ip); err == nil && loc != nil {
+		if e.opts.ShowTimeZone {
+			result.TimeZone = timeZoneText(loc.Country)
+		}
+		if e.opts.DistanceFrom != nil {
+			if coords, ok := e.coordinates(ip, loc); ok {
+				result.Distance = distanceText(*e.opts.DistanceFrom, coords)
+			}
+		}
+		loc = localize(loc, e.opts.Locale)
+		result.Location = loc
+		result.foreign = !e.isHome(loc.Country)
+		result.Label = e.template.Location(loc)
+		if e.opts.ShowISP && !e.template.Has("isp") && loc.ISP != "" && loc.ISP != "0" {
+			if result.Label == "Unknown" {
+				result.Label = loc.ISP
+			} else {
+				result.Label += " / " + loc.ISP
+			}
+		}
+		if result.Label != "Unknown" {
+			result.color = locationColor(!result.foreign)
+		}
+		if e.opts.Flag {
+			if flag := FlagEmoji(CountryCode(loc.Country)); flag != "" {
+				result.Label = flag + " " + result.Label
+			}
+		}
+	} else {
+		result.Label = e.unknownLabel(err)
+		e.opts.Logger.Debug("lookup failed", "ip", ip, "err", err)
+	}
+	if !external {
+		return result
+	}
+	if e.opts.RDNS && !result.Special {
+		result.Hostname = e.reverseDNS(key)
+	}
+	if e.opts.ASN != nil && !result.Special {
+		if number, org, err := e.queryASN(key); err == nil {
+			result.ASN, result.ASOrg = number, org
+		}
+	}
+	if e.annotators != nil {
+		result.notes = e.notes(key, result.Location)
+	}
+	return result
+}
+
+// unknownLabel returns the label of an IP the database couldn't resolve
+func (e *Enricher) unknownLabel(err error) string {
+	switch {
+	case !e.opts.ErrorLabels && !e.opts.Verbose:
+		return "Unknown"
+	case err == nil || errors.Is(err, ErrNotFound):
+		return "NotFound"
+	case e.opts.Verbose:
+		return "LookupError: " + err.Error()
+	default:
+		return "LookupError"
+	}
+}
+
+// errNoDatabase is the lookup error of an Enricher without a database
+var errNoDatabase = errors.New("no IP database loaded")
+
+// query looks ip up in the database, turning a panic of a corrupt
+// database into an error so one bad record can't end the stream
+func (e *Enricher) query(ip string) (loc *qqwry.Location, err error) {
+	if e.db == nil {
+		e.warn(errNoDatabase)
+		return nil, errNoDatabase
+	}
+	defer e.recoverLookup(ip, &err)
+	return e.db.Query(ip)
+}
+
+// queryASN looks up the autonomous system of ip, recovering like query
+func (e *Enricher) queryASN(ip string) (number uint, org string, err error) {
+	defer e.recoverLookup(ip, &err)
+	return e.opts.ASN.QueryASN(ip)
+}
+
+// recoverLookup is deferred by lookups to report a panic as *err
+func (e *Enricher) recoverLookup(ip string, err *error) {
+	if r := recover(); r != nil {
+		*err = fmt.Errorf("lookup of %s panicked: %v", ip, r)
+		e.warn(*err)
+	}
+}
+
+// warn reports the first database failure to opts.Warnings
+func (e *Enricher) warn(err error) {
+	e.warnOnce.Do(func() {
+		if e.opts.Warnings != nil {
+			fmt.Fprintf(e.opts.Warnings, "Warning: %v; affected IPs are left unresolved\n", err)
+		}
+	})
+}
+
+// Preload resolves ip and stores it in the lookup cache, so its first
+// appearance in the input is answered without a database query
+func (e *Enricher) Preload(ip string) error {
+	if net.ParseIP(strings.Trim(ip, "[]")) == nil {
+		return fmt.Errorf("invalid IP: %s", ip)
+	}
+	e.lookup(ip)
+	return nil
+}
+
+// CacheStats returns how many lookups were answered from the cache
+// and how many had to query the database
+func (e *Enricher) CacheStats() (hits, misses uint64) {
+	return e.hits.Load(), e.misses.Load()
+}
+
+// reverseDNS returns the first PTR name of ip, or "" if there is none
+func (e *Enricher) reverseDNS(ip string) string {
+	timeout := e.opts.RDNSTimeout
+	if timeout <= 0 {
+		timeout = defaultRDNSTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	names, err := net.DefaultResolver.LookupAddr(ctx, ip)
+	if err != nil || len(names) == 0 {
+		return ""
+	}
+	return strings.TrimSuffix(names[0], ".")
+}
+
+// Resolve finds all IPs in a line and resolves their locations
+func (e *Enricher) Resolve(line string) []Result {
+	if e.opts.Passthrough {
+		return nil
+	}
+	matches := e.findIPs(line)
+	kept := matches[:0]
+	for _, match := range matches {
+		if e.opts.SkipURLs && inURL(line, match) {
+			continue
+		}
+		if e.opts.SkipTimestamps && timestampLike(line, match) {
+			continue
+		}
+		if inNets(match.IP, e.opts.SkipNets) ||
+			(len(e.opts.OnlyNets) > 0 && !inNets(match.IP, e.opts.OnlyNets)) {
+			continue
+		}
+		if e.opts.MaxIPsPerLine > 0 && len(kept) >= e.opts.MaxIPsPerLine {
+			// Leave the rest of a pathological line alone
+			break
+		}
+		if e.opts.FirstIPOnly && len(kept) > 0 {
+			// Keep only the leftmost match
+			if match.Start > kept[0].Start {
+				continue
+			}
+			kept = kept[:0]
+		}
+		kept = append(kept, match)
+	}
+
+	results := make([]Result, 0, len(kept))
+	for _, match := range kept {
+		results = append(results, Result{Match: match, Lookup: e.lookupWithin(match.IP)})
+	}
+	return results
+}
+
+// findIPs finds the IPs in line, including integers and zero-padded
+// addresses if the options ask for them
+func (e *Enricher) findIPs(line string) []Match {
+	matches := findAllIPs(line)
+	if e.opts.DecodeIntIPs {
+		matches = removeOverlaps(append(matches, findIntIPs(line)...))
+	}
+	if e.opts.LeadingZeros {
+		matches = removeOverlaps(append(matches, findZeroPaddedIPs(line)...))
+	}
+	return matches
+}
+
+// Render splices location annotations for results into the line next to
+// each IP, or in place of each IP with Options.Replace
+func (e *Enricher) Render(line string, results []Result) string {
+	if len(results) == 0 {
+		return line
+	}
+
+	// Sort results by position, leaving the caller's slice untouched
+	results = append([]Result(nil), results...)
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Start < results[j].Start
+	})
+
+	// Remember where each IP first appears
+	var firstSeen map[string]int
+	if e.opts.DedupLine {
+		firstSeen = make(map[string]int, len(results))
+		for _, result := range results {
+			if _, ok := firstSeen[normalizeIP(result.IP)]; !ok {
+				firstSeen[normalizeIP(result.IP)] = result.Start
+			}
+		}
+	}
+
+	skipped := func(result Result) bool {
+		if e.opts.ForeignOnly && !result.IsForeign() {
+			return true
+		}
+		// Leave later occurrences of an IP annotated earlier in the line
+		return firstSeen != nil && firstSeen[normalizeIP(result.IP)] != result.Start
+	}
+
+	// Claim the IPs this line is the first to annotate, from left to right
+	if e.opts.FirstSeenOnly {
+		fresh := make(map[int]bool, len(results))
+		for _, result := range results {
+			if skipped(result) {
+				continue
+			}
+			if _, seen := e.seen.LoadOrStore(normalizeIP(result.IP), struct{}{}); !seen {
+				fresh[result.Start] = true
+			}
+		}
+		lineSkipped := skipped
+		skipped = func(result Result) bool {
+			return lineSkipped(result) || !fresh[result.Start]
+		}
+	}
+	if e.opts.GroupLists && !e.opts.Replace {
+		return applySplices(line, e.groupSplices(line, results, skipped))
+	}
+	if e.opts.AnnotationColumn > 0 && !e.opts.Replace {
+		return e.annotateColumn(line, results, skipped)
+	}
+
+	splices := make([]splice, 0, len(results))
+	for _, result := range results {
+		if skipped(result) {
+			continue
+		}
+
+		if !e.opts.Replace {
+			splices = append(splices, e.annotate(line, result))
+		} else if result.resolved() {
+			splices = append(splices, e.replace(result))
+		}
+	}
+
+	return applySplices(line, splices)
+}
+
+// splice replaces line[start:end] with text when a line is rendered
+type splice struct {
+	start, end int
+	text       string
+}
+
+// applySplices builds line with each splice applied in a single pass;
+// splices must be sorted by position and must not overlap
+//
+// A splice breaking that, or reaching past the line, is dropped rather
+// than let odd input garble the text around it or panic.
+func applySplices(line string, splices []splice) string {
+	if len(splices) == 0 {
+		return line
+	}
+
+	var out strings.Builder
+	grow := len(line)
+	for _, s := range splices {
+		grow += len(s.text) - (s.end - s.start)
+	}
+	out.Grow(max(grow, len(line)))
+
+	last := 0
+	for _, s := range splices {
+		if s.start < last || s.end < s.start || s.end > len(line) {
+			continue
+		}
+		out.WriteString(line[last:s.start])
+		out.WriteString(s.text)
+		last = s.end
+	}
+	out.WriteString(line[last:])
+	return out.String()
+}
+
+// label returns the text shown for a result: its location, cut to
+// Options.MaxLocWidth and after the address if it was decoded, plus the
+// time zone, distance, ASN and reverse DNS name if known
+func (e *Enricher) label(result Result) string {
+	label := truncateWidth(result.Label, e.opts.MaxLocWidth)
+	if result.notes != nil {
+		label = e.composeNotes(label, result.notes)
+	}
+	if result.Decoded {
+		label = result.IP + " / " + label
+	}
+	if result.TimeZone != "" {
+		label += ", " + result.TimeZone
+	}
+	if result.Distance != "" {
+		label += ", " + result.Distance
+	}
+	if result.ASN != 0 {
+		label += " / " + strings.TrimSpace(fmt.Sprintf("AS%d %s", result.ASN, result.ASOrg))
+	}
+	if result.Hostname != "" {
+		label += " / " + result.Hostname
+	}
+	return label
+}
+
+// annotate returns the splice putting the annotation of result next
+// to its IP in line
+func (e *Enricher) annotate(line string, result Result) splice {
+	annotation := padRight(e.template.Wrap(e.label(result)), e.opts.PadWidth)
+	if e.opts.Color {
+		annotation = result.color + annotation + colorReset
+	}
+
+	// Mark the IP itself
+	ip := line[result.Start:result.End]
+	if e.opts.Color && e.opts.Highlight {
+		ip = highlightStart + ip + highlightEnd
+	}
+
+	switch {
+	case e.opts.Placement == PlacementBefore:
+		return splice{result.Start, result.End, annotation + " " + ip}
+	case e.opts.AfterPort && result.PortEnd > result.End:
+		return splice{result.Start, result.PortEnd, ip + line[result.End:result.PortEnd] + " " + annotation}
+	default:
+		return splice{result.Start, result.End, ip + annotation}
+	}
+}
+
+// replace returns the splice substituting the IP of result with its
+// bare label; callers keep IPs whose location is unknown
+func (e *Enricher) replace(result Result) splice {
+	label := padRight(e.label(result), e.opts.PadWidth)
+	if e.opts.Color {
+		label = result.color + label + colorReset
+	}
+	return splice{result.Start, result.End, label}
+}
+
+// Enrich processes a line of text and adds location annotations to IP addresses
+func (e *Enricher) Enrich(line string) string {
+	return e.Render(line, e.Resolve(line))
+}
+
+// minBatchChunk is the fewest lines EnrichBatch hands to one goroutine
+const minBatchChunk = 256
+
+// EnrichBatch enriches many lines at once, returning them in the same order
+//
+// Lines are split into chunks enriched in parallel across GOMAXPROCS
+// goroutines, all sharing the lookup cache.
+func (e *Enricher) EnrichBatch(lines []string) []string {
+	enriched := make([]string, len(lines))
+
+	chunk := max((len(lines)+runtime.GOMAXPROCS(0)-1)/runtime.GOMAXPROCS(0), minBatchChunk)
+	var wg sync.WaitGroup
+	for start := 0; start < len(lines); start += chunk {
+		end := min(start+chunk, len(lines))
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := start; i < end; i++ {
+				enriched[i] = e.Enrich(lines[i])
+			}
+		}()
+	}
+	wg.Wait()
+
+	return enriched
+}
+
+// IPRecord is the JSON representation of a resolved IP
+type IPRecord struct {
+	IP       string `json:"ip"`
+	Country  string `json:"country"`
+	Province string `json:"province"`
+	City     string `json:"city"`
+	Special  bool   `json:"special"`
+	Hostname string `json:"hostname,omitempty"`
+	ASN      uint   `json:"asn,omitempty"`
+	ASOrg    string `json:"as_org,omitempty"`
+	TimeZone string `json:"time_zone,omitempty"`
+	Distance string `json:"distance,omitempty"`
+
+	// Texts of the annotators besides geo, keyed by name
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// LineRecord is the JSON representation of a processed line
+type LineRecord struct {
+	Line string     `json:"line"`
+	IPs  []IPRecord `json:"ips"`
+}
+
+// EnrichJSON processes a line of text and returns a JSON record of its IP addresses
+func (e *Enricher) EnrichJSON(line string) ([]byte, error) {
+	return e.RenderJSON(line, e.Resolve(line))
+}
+
+// RenderJSON returns a JSON record of the line and its resolved results
+func (e *Enricher) RenderJSON(line string, results []Result) ([]byte, error) {
+	record := LineRecord{Line: line, IPs: []IPRecord{}}
+	for _, result := range results {
+		ipRecord := IPRecord{
+			IP:       result.IP,
+			Special:  result.Special,
+			Hostname: result.Hostname,
+			ASN:      result.ASN,
+			ASOrg:    result.ASOrg,
+			TimeZone: result.TimeZone,
+			Distance: result.Distance,
+
+			Annotations: e.noteMap(result.notes),
+		}
+		if result.Location != nil {
+			ipRecord.Country = result.Location.Country
+			ipRecord.Province = result.Location.Province
+			ipRecord.City = result.Location.City
+		}
+		record.IPs = append(record.IPs, ipRecord)
+	}
+	return json.Marshal(record)
+}
@@ -0,0 +1,163 @@
+package enrich
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/xiaoqidun/qqwry"
+)
+
+// fakeBackend answers every query with a fixed location, so tests and
+// benchmarks don't need a database file
+type fakeBackend struct{}
+
+func (fakeBackend) Query(ip string) (*qqwry.Location, error) {
+	return &qqwry.Location{Country: "美国", Province: "加利福尼亚", City: "山景城", ISP: "谷歌", IP: ip}, nil
+}
+
+// newTestEnricher returns an enricher over fakeBackend
+func newTestEnricher(tb testing.TB, opts Options) *Enricher {
+	tb.Helper()
+	e, err := New(fakeBackend{}, opts)
+	if err != nil {
+		tb.Fatalf("New: %v", err)
+	}
+	return e
+}
+
+// benchLines returns n log-like lines with one or two IPs each, spread
+// over enough addresses that the cache doesn't answer them all
+func benchLines(n int) []string {
+	lines := make([]string, n)
+	for i := range lines {
+		ip := fmt.Sprintf("%d.%d.%d.%d", 1+i%223, i/223%256, i/57088%256, 1+i%254)
+		if i%3 == 0 {
+			lines[i] = fmt.Sprintf("Accepted publickey for root from %s port %d ssh2", ip, 1024+i%60000)
+		} else {
+			lines[i] = fmt.Sprintf("%s - - [10/Oct/2026:13:55:36 +0000] \"GET / HTTP/1.1\" 200 612 via 10.0.0.%d", ip, i%256)
+		}
+	}
+	return lines
+}
+
+// benchLineCount is the size of the input the benchmarks enrich
+const benchLineCount = 100_000
+
+func BenchmarkEnrich(b *testing.B) {
+	lines := benchLines(benchLineCount)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		e := newTestEnricher(b, Options{})
+		for _, line := range lines {
+			e.Enrich(line)
+		}
+	}
+}
+
+func BenchmarkEnrichBatch(b *testing.B) {
+	lines := benchLines(benchLineCount)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		e := newTestEnricher(b, Options{})
+		e.EnrichBatch(lines)
+	}
+}
+
+func TestEnrichBatchMatchesEnrich(t *testing.T) {
+	lines := benchLines(5000)
+	batch := newTestEnricher(t, Options{}).EnrichBatch(lines)
+	e := newTestEnricher(t, Options{})
+	for i, line := range lines {
+		if want := e.Enrich(line); batch[i] != want {
+			t.Fatalf("line %d: EnrichBatch = %q, Enrich = %q", i, batch[i], want)
+		}
+	}
+}
+
+// fuzzOptions returns the enricher options selected by the bits of mode
+func fuzzOptions(mode uint8) Options {
+	opts := Options{
+		AfterPort:  mode&1 != 0,
+		Color:      mode&4 != 0,
+		Highlight:  mode&8 != 0,
+		GroupLists: mode&16 != 0,
+	}
+	if mode&2 != 0 {
+		opts.Placement = PlacementBefore
+	}
+	return opts
+}
+
+// checkSplices fails t unless the annotation splices of results are in
+// order, within line and apart, and keep the text they replace
+func checkSplices(t *testing.T, e *Enricher, line string, results []Result) {
+	t.Helper()
+	results = append([]Result(nil), results...)
+	sort.Slice(results, func(i, j int) bool { return results[i].Start < results[j].Start })
+
+	last := 0
+	for _, result := range results {
+		s := e.annotate(line, result)
+		if s.start < last || s.end < s.start || s.end > len(line) {
+			t.Fatalf("splice %+v out of order or bounds (previous end %d) in %q", s, last, line)
+		}
+		last = s.end
+		text := strings.NewReplacer(highlightStart, "", highlightEnd, "").Replace(s.text)
+		if !strings.Contains(text, line[s.start:s.end]) {
+			t.Fatalf("splice %+v drops %q from %q", s, line[s.start:s.end], line)
+		}
+	}
+}
+
+func FuzzEnrich(f *testing.F) {
+	for _, seed := range matchSeeds {
+		for mode := uint8(0); mode < 32; mode++ {
+			f.Add(seed, mode)
+		}
+	}
+	f.Fuzz(func(t *testing.T, line string, mode uint8) {
+		e := newTestEnricher(t, fuzzOptions(mode))
+		checkSplices(t, e, line, e.Resolve(line))
+
+		// Stripping the annotations gives back the input, unless the
+		// input had annotations of its own to begin with
+		if e.Strip(line) != line {
+			return
+		}
+		enriched := e.Enrich(line)
+		if stripped := e.Strip(enriched); stripped != line {
+			t.Fatalf("Strip(Enrich(%q)) = %q via %q", line, stripped, enriched)
+		}
+	})
+}
+
+func TestStripDoesNotLookUp(t *testing.T) {
+	// The command would fail the lookups it runs, and a cached failure
+	// would count as a miss
+	e := newTestEnricher(t, Options{GroupLists: true, LookupCommand: []string{"false"}})
+	line := "from 8.8.8.8, 1.1.1.1 [加利福尼亚山景城谷歌, 加利福尼亚山景城谷歌] via 9.9.9.9(加利福尼亚山景城谷歌) and 10.0.0.1(Local)"
+	if got, want := e.Strip(line), "from 8.8.8.8, 1.1.1.1 via 9.9.9.9 and 10.0.0.1"; got != want {
+		t.Errorf("Strip = %q, want %q", got, want)
+	}
+	if _, misses := e.CacheStats(); misses != 0 {
+		t.Errorf("Strip looked up %d IPs, want none", misses)
+	}
+}
+
+func TestStripKeepsForeignText(t *testing.T) {
+	for _, mode := range []uint8{16, 17, 18} {
+		e := newTestEnricher(t, fuzzOptions(mode))
+		for _, line := range []string{
+			"peer (backup) 8.8.8.8 up",
+			"route 8.8.8.8:53 (udp) ok",
+			"ACK from 10.0.0.1(eth0)",
+			"from 8.8.8.8, 1.1.1.1 [primary, backup]",
+		} {
+			if got := e.Strip(line); got != line {
+				t.Errorf("mode %d: Strip(%q) = %q, want it unchanged", mode, line, got)
+			}
+		}
+	}
+}
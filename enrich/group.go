@@ -0,0 +1,87 @@
+package enrich
+
+import "strings"
+
+// groupList returns the run of results that continues rightwards from
+// results[0], e.g. the addresses of an X-Forwarded-For header; results
+// must be sorted by position
+func groupList(line string, results []Result) []Result {
+	n := 1
+	for n < len(results) && isListSeparator(line[results[n-1].PortEnd:results[n].Start]) {
+		n++
+	}
+	return results[:n]
+}
+
+// isListSeparator reports whether text between two IPs makes them items
+// of one list: a comma with optional blanks, or a single space, which
+// keeps the wider gaps between table columns out
+func isListSeparator(text string) bool {
+	if text == " " {
+		return true
+	}
+	return strings.Count(text, ",") == 1 && strings.Trim(text, ", \t") == ""
+}
+
+// groupSplices returns the splices annotating results like Render, but
+// annotating a list of IPs once, with the labels of all its items in
+// brackets after the list (or before it with PlacementBefore); skipped
+// items show as "-". results must be sorted by position.
+func (e *Enricher) groupSplices(line string, results []Result, skipped func(Result) bool) []splice {
+	splices := []splice{}
+	for i := 0; i < len(results); {
+		group := groupList(line, results[i:])
+		i += len(group)
+
+		if len(group) == 1 {
+			if !skipped(group[0]) {
+				splices = append(splices, e.annotate(line, group[0]))
+			}
+			continue
+		}
+		if s, ok := e.annotateGroup(line, group, skipped); ok {
+			splices = append(splices, s)
+		}
+	}
+	return splices
+}
+
+// annotateGroup returns the splice adding the bracketed labels of a list
+// of IPs, or false if all of them are skipped
+func (e *Enricher) annotateGroup(line string, group []Result, skipped func(Result) bool) (splice, bool) {
+	labels := make([]string, len(group))
+	shown := false
+	for i, result := range group {
+		if skipped(result) {
+			labels[i] = "-"
+			continue
+		}
+		labels[i], shown = e.label(result), true
+		if e.opts.Color {
+			labels[i] = result.color + labels[i] + colorReset
+		}
+	}
+	if !shown {
+		return splice{}, false
+	}
+	annotation := "[" + strings.Join(labels, ", ") + "]"
+
+	// The list itself, with its IPs marked
+	first, last := group[0], group[len(group)-1]
+	var list strings.Builder
+	pos := first.Start
+	for _, result := range group {
+		if skipped(result) || !e.opts.Color || !e.opts.Highlight {
+			continue
+		}
+		list.WriteString(line[pos:result.Start])
+		list.WriteString(highlightStart + line[result.Start:result.End] + highlightEnd)
+		pos = result.End
+	}
+	list.WriteString(line[pos:last.PortEnd])
+
+	if e.opts.Placement == PlacementBefore {
+		return splice{first.Start, last.PortEnd, annotation + " " + list.String()}, true
+	}
+	return splice{first.Start, last.PortEnd, list.String() + " " + annotation}, true
+}
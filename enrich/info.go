@@ -0,0 +1,102 @@
+package enrich
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"time"
+)
+
+// Info is metadata of a loaded database, zero where unknown
+type Info struct {
+	Format  string    // file format, e.g. QQWryFormatIPDB or the MaxMind database type
+	Built   time.Time // when the database was built
+	Records uint64    // number of IP ranges, or of tree nodes for tree formats
+	Version string    // version text the database carries, e.g. in its last record
+}
+
+// Describer is implemented by backends that can report their metadata
+type Describer interface {
+	// Info returns metadata of the loaded database
+	Info() Info
+}
+
+// qqwryInfo reads the metadata of a qqwry database from its header;
+// data must have passed detectQQWryFormat
+func qqwryInfo(data []byte, format string) Info {
+	info := Info{Format: format}
+	if format == QQWryFormatDat {
+		first := binary.LittleEndian.Uint32(data[:4])
+		last := binary.LittleEndian.Uint32(data[4:8])
+		info.Records = uint64((last-first)/7 + 1)
+		return info
+	}
+
+	// An ipdb file starts with the length of its JSON metadata
+	var meta struct {
+		Build     int64  `json:"build"`
+		NodeCount uint64 `json:"node_count"`
+	}
+	size := binary.BigEndian.Uint32(data[:4])
+	if uint64(size)+4 <= uint64(len(data)) && json.Unmarshal(data[4:4+size], &meta) == nil {
+		if meta.Build > 0 {
+			info.Built = time.Unix(meta.Build, 0)
+		}
+		info.Records = meta.NodeCount
+	}
+	return info
+}
+
+// Info returns the format of the database and what its header records
+//
+// A qqwry.dat carries its release in the location of its last range,
+// e.g. "纯真网络 2024年1月1日IP数据".
+func (d *QQWry) Info() Info {
+	info := d.info
+	if d.format == QQWryFormatDat {
+		if loc, err := d.Query("255.255.255.255"); err == nil && loc != nil {
+			info.Version = joinNonEmpty(loc.Country, loc.Province, loc.City, loc.ISP)
+		}
+	}
+	return info
+}
+
+// Info returns the type, build time and node count of the database
+func (d *MaxMind) Info() Info {
+	meta := d.reader.Metadata
+	return Info{
+		Format:  meta.DatabaseType,
+		Built:   time.Unix(int64(meta.BuildEpoch), 0),
+		Records: uint64(meta.NodeCount),
+	}
+}
+
+// Info returns the creation time and segment count of the database
+// recorded in its header
+func (d *IP2Region) Info() Info {
+	header := d.data[:xdbHeaderSize]
+	info := Info{Format: "xdb"}
+	if created := binary.LittleEndian.Uint32(header[4:8]); created > 0 {
+		info.Built = time.Unix(int64(created), 0)
+	}
+	start := binary.LittleEndian.Uint32(header[8:12])
+	end := binary.LittleEndian.Uint32(header[12:16])
+	if end >= start {
+		info.Records = uint64((end-start)/xdbSegmentSize + 1)
+	}
+	return info
+}
+
+// joinNonEmpty joins the parts that aren't empty or "0" with spaces
+func joinNonEmpty(parts ...string) string {
+	text := ""
+	for _, part := range parts {
+		if part == "" || part == "0" {
+			continue
+		}
+		if text != "" {
+			text += " "
+		}
+		text += part
+	}
+	return text
+}
@@ -0,0 +1,121 @@
+package enrich
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/xiaoqidun/qqwry"
+)
+
+// ip2region xdb layout: a 256 byte header, then a 256x256 vector index of
+// (start, end) segment pointers keyed by the first two octets, then 14 byte
+// segment index entries of (start IP, end IP, data length, data pointer)
+const (
+	xdbHeaderSize      = 256
+	xdbVectorIndexCols = 256
+	xdbVectorIndexSize = 8
+	xdbSegmentSize     = 14
+)
+
+// IP2Region is a loaded ip2region xdb database
+//
+// The whole file is kept in memory, so lookups never touch the disk.
+type IP2Region struct {
+	path string
+	data []byte
+}
+
+// OpenIP2Region loads the ip2region .xdb database at path, which may be gzipped
+func OpenIP2Region(path string) (*IP2Region, error) {
+	data, err := readDatabase(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load ip2region database: %w", err)
+	}
+	if len(data) < xdbHeaderSize+xdbVectorIndexCols*xdbVectorIndexCols*xdbVectorIndexSize {
+		return nil, fmt.Errorf("failed to load ip2region database: file too small")
+	}
+	return &IP2Region{path: path, data: data}, nil
+}
+
+// Path returns the file the database was loaded from
+func (d *IP2Region) Path() string {
+	return d.path
+}
+
+// Query looks up the location of an IPv4 address; the database has no
+// IPv6 entries, so those are ErrNotFound
+func (d *IP2Region) Query(ip string) (*qqwry.Location, error) {
+	parsedIP := net.ParseIP(ip).To4()
+	if parsedIP == nil {
+		return nil, ErrNotFound
+	}
+	ip32 := binary.BigEndian.Uint32(parsedIP)
+
+	region, err := d.search(ip32)
+	if err != nil {
+		return nil, err
+	}
+	return parseRegion(region, ip), nil
+}
+
+// search finds the region string of ip32 by binary search over the
+// segments the vector index points at
+func (d *IP2Region) search(ip32 uint32) (string, error) {
+	idx := xdbHeaderSize + (int(ip32>>24)*xdbVectorIndexCols+int(ip32>>16&0xFF))*xdbVectorIndexSize
+	startPtr := int(binary.LittleEndian.Uint32(d.data[idx:]))
+	endPtr := int(binary.LittleEndian.Uint32(d.data[idx+4:]))
+
+	low, high := 0, (endPtr-startPtr)/xdbSegmentSize
+	for low <= high {
+		mid := (low + high) / 2
+		pos := startPtr + mid*xdbSegmentSize
+		if pos+xdbSegmentSize > len(d.data) {
+			return "", errors.New("corrupt ip2region database")
+		}
+
+		segment := d.data[pos : pos+xdbSegmentSize]
+		switch {
+		case ip32 < binary.LittleEndian.Uint32(segment[0:]):
+			high = mid - 1
+		case ip32 > binary.LittleEndian.Uint32(segment[4:]):
+			low = mid + 1
+		default:
+			dataLen := int(binary.LittleEndian.Uint16(segment[8:]))
+			dataPtr := int(binary.LittleEndian.Uint32(segment[10:]))
+			if dataPtr+dataLen > len(d.data) {
+				return "", errors.New("corrupt ip2region database")
+			}
+			return string(d.data[dataPtr : dataPtr+dataLen]), nil
+		}
+	}
+
+	return "", ErrNotFound
+}
+
+// parseRegion maps an ip2region "国家|区域|省份|城市|ISP" string (or the
+// newer four-field "国家|省份|城市|ISP") onto a Location; "0" means empty
+func parseRegion(region, ip string) *qqwry.Location {
+	fields := strings.Split(region, "|")
+	for i, field := range fields {
+		if field == "0" {
+			fields[i] = ""
+		}
+	}
+	if len(fields) == 5 {
+		fields = append(fields[:1], fields[2:]...) // drop 区域
+	}
+	for len(fields) < 4 {
+		fields = append(fields, "")
+	}
+
+	return &qqwry.Location{
+		IP:       ip,
+		Country:  fields[0],
+		Province: fields[1],
+		City:     fields[2],
+		ISP:      fields[3],
+	}
+}
@@ -0,0 +1,121 @@
+package enrich
+
+import (
+	"encoding/json"
+	"io"
+	"sort"
+	"strings"
+)
+
+// jsonFrame tracks an object or array while walking a JSON line
+type jsonFrame struct {
+	object  bool
+	wantKey bool   // next token in the object is a key
+	key     string // key of the value being read
+}
+
+// jsonInsert is a sibling field to splice into a JSON line
+type jsonInsert struct {
+	pos   int // offset right after the annotated string value
+	field string
+}
+
+// RenderJSONLine annotates IPs in the string values of a JSON line by adding
+// a sibling "<key>_geo" field after each such value, keeping the line valid
+// JSON and its formatting intact
+//
+// Strings inside arrays have no key to derive a sibling from and are left
+// alone. It returns false if line is not a JSON object or array.
+func (e *Enricher) RenderJSONLine(line string) (string, bool) {
+	trimmed := strings.TrimSpace(line)
+	if trimmed == "" || (trimmed[0] != '{' && trimmed[0] != '[') || !json.Valid([]byte(line)) {
+		return line, false
+	}
+
+	dec := json.NewDecoder(strings.NewReader(line))
+	dec.UseNumber()
+
+	var stack []*jsonFrame
+	var inserts []jsonInsert
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return line, false
+		}
+
+		var top *jsonFrame
+		if len(stack) > 0 {
+			top = stack[len(stack)-1]
+		}
+
+		// Keys, and the end of an object in place of one
+		if top != nil && top.object && top.wantKey {
+			if key, ok := tok.(string); ok {
+				top.key, top.wantKey = key, false
+				continue
+			}
+			stack = stack[:len(stack)-1]
+			jsonValueDone(stack)
+			continue
+		}
+
+		switch t := tok.(type) {
+		case json.Delim:
+			switch t {
+			case '{':
+				stack = append(stack, &jsonFrame{object: true, wantKey: true})
+			case '[':
+				stack = append(stack, &jsonFrame{})
+			case ']':
+				stack = stack[:len(stack)-1]
+				jsonValueDone(stack)
+			}
+			continue
+		case string:
+			if top != nil && top.object {
+				if field := e.jsonGeoField(top.key, t); field != "" {
+					inserts = append(inserts, jsonInsert{pos: int(dec.InputOffset()), field: field})
+				}
+			}
+		}
+		jsonValueDone(stack)
+	}
+
+	// Splice from right to left so earlier offsets stay valid
+	sort.Slice(inserts, func(i, j int) bool {
+		return inserts[i].pos > inserts[j].pos
+	})
+	for _, insert := range inserts {
+		line = line[:insert.pos] + insert.field + line[insert.pos:]
+	}
+	return line, true
+}
+
+// jsonValueDone marks the value of the innermost object as read
+func jsonValueDone(stack []*jsonFrame) {
+	if len(stack) > 0 && stack[len(stack)-1].object {
+		stack[len(stack)-1].wantKey = true
+	}
+}
+
+// jsonGeoField returns `,"<key>_geo":"<labels>"` for the IPs in value,
+// or "" if it has none worth annotating
+func (e *Enricher) jsonGeoField(key, value string) string {
+	var labels []string
+	for _, result := range e.Resolve(value) {
+		if e.opts.ForeignOnly && !result.IsForeign() {
+			continue
+		}
+		labels = append(labels, e.label(result))
+	}
+	if len(labels) == 0 {
+		return ""
+	}
+
+	name, _ := json.Marshal(key + "_geo")
+	label, _ := json.Marshal(strings.Join(labels, ", "))
+	return "," + string(name) + ":" + string(label)
+}
@@ -0,0 +1,111 @@
+package enrich
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/xiaoqidun/qqwry"
+)
+
+// Locales accepted by Options.Locale
+const (
+	LocaleNative  = ""   // names as the database returns them
+	LocaleEnglish = "en" // English country and province names where known
+)
+
+// provinceNames maps Chinese provinces and regions, without their
+// 省/市/自治区 suffix, to English
+var provinceNames = map[string]string{
+	"北京":  "Beijing",
+	"天津":  "Tianjin",
+	"上海":  "Shanghai",
+	"重庆":  "Chongqing",
+	"河北":  "Hebei",
+	"山西":  "Shanxi",
+	"辽宁":  "Liaoning",
+	"吉林":  "Jilin",
+	"黑龙江": "Heilongjiang",
+	"江苏":  "Jiangsu",
+	"浙江":  "Zhejiang",
+	"安徽":  "Anhui",
+	"福建":  "Fujian",
+	"江西":  "Jiangxi",
+	"山东":  "Shandong",
+	"河南":  "Henan",
+	"湖北":  "Hubei",
+	"湖南":  "Hunan",
+	"广东":  "Guangdong",
+	"海南":  "Hainan",
+	"四川":  "Sichuan",
+	"贵州":  "Guizhou",
+	"云南":  "Yunnan",
+	"陕西":  "Shaanxi",
+	"甘肃":  "Gansu",
+	"青海":  "Qinghai",
+	"台湾":  "Taiwan",
+	"内蒙古": "Inner Mongolia",
+	"广西":  "Guangxi",
+	"西藏":  "Tibet",
+	"宁夏":  "Ningxia",
+	"新疆":  "Xinjiang",
+	"香港":  "Hong Kong",
+	"澳门":  "Macau",
+}
+
+// provinceSuffixes are stripped before looking up provinceNames,
+// longest first
+var provinceSuffixes = []string{
+	"维吾尔自治区", "壮族自治区", "回族自治区", "特别行政区", "自治区", "省", "市",
+}
+
+// validLocale checks that locale is one we have names for
+func validLocale(locale string) error {
+	switch locale {
+	case LocaleNative, LocaleEnglish:
+		return nil
+	default:
+		return fmt.Errorf("unknown locale: %s (expected en)", locale)
+	}
+}
+
+// localize returns a copy of loc with its country and province names
+// translated to locale, keeping the original names that have no translation
+func localize(loc *qqwry.Location, locale string) *qqwry.Location {
+	if locale != LocaleEnglish {
+		return loc
+	}
+
+	translated := *loc
+	translated.Country = englishCountry(loc.Country)
+	translated.Province = englishProvince(loc.Province)
+	return &translated
+}
+
+// englishCountry returns the English name of a country, or name itself
+func englishCountry(name string) string {
+	code := CountryCode(name)
+	for _, c := range countries {
+		if c.code == code {
+			return c.en
+		}
+	}
+	return name
+}
+
+// englishProvince returns the English name of a Chinese province, or name itself
+func englishProvince(name string) string {
+	if english, ok := provinceNames[shortProvince(name)]; ok {
+		return english
+	}
+	return name
+}
+
+// shortProvince strips the 省/市/自治区 suffix of a province name
+func shortProvince(name string) string {
+	for _, suffix := range provinceSuffixes {
+		if trimmed := strings.TrimSuffix(name, suffix); trimmed != name && trimmed != "" {
+			return trimmed
+		}
+	}
+	return name
+}
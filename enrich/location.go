@@ -0,0 +1,133 @@
+package enrich
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+const (
+	// Country name the database uses for domestic addresses,
+	// unless Options.HomeCountry says otherwise
+	defaultHomeCountry = "中国"
+
+	// ANSI color codes for annotations
+	colorReset = "\033[0m"
+	colorGreen = "\033[32m"
+	colorRed   = "\033[31m"
+	colorGray  = "\033[90m"
+
+	// ANSI codes marking the matched IP itself; the end code only turns
+	// bold and underline off, so colors of the input survive
+	highlightStart = "\033[1;4m"
+	highlightEnd   = "\033[22;24m"
+)
+
+// IsSpecialIP checks if the IP is special (loopback, private, etc.)
+func IsSpecialIP(ip string) bool {
+	// Remove possible brackets
+	ip = strings.Trim(ip, "[]")
+
+	parsedIP := net.ParseIP(ip)
+	if parsedIP == nil {
+		return false
+	}
+
+	// Check if it's loopback, unspecified, link-local, or private address;
+	// IsPrivate includes IPv6 unique-local addresses (fc00::/7)
+	if parsedIP.IsLoopback() || parsedIP.IsUnspecified() ||
+		parsedIP.IsLinkLocalUnicast() || parsedIP.IsLinkLocalMulticast() ||
+		parsedIP.IsPrivate() {
+		return true
+	}
+
+	return false
+}
+
+// reservedNets are ranges that can never be geolocated
+var reservedNets = parseCIDRs(
+	"0.0.0.0/8",       // "this" network
+	"100.64.0.0/10",   // carrier-grade NAT
+	"192.0.0.0/24",    // IETF protocol assignments
+	"192.0.2.0/24",    // documentation (TEST-NET-1)
+	"192.88.99.0/24",  // 6to4 relay anycast
+	"198.18.0.0/15",   // benchmarking
+	"198.51.100.0/24", // documentation (TEST-NET-2)
+	"203.0.113.0/24",  // documentation (TEST-NET-3)
+	"240.0.0.0/4",     // reserved for future use
+	"2001::/32",       // Teredo
+	"2001:2::/48",     // benchmarking
+	"2001:db8::/32",   // documentation
+	"3fff::/20",       // documentation
+	"2002::/16",       // 6to4
+	"100::/64",        // discard-only
+	"fec0::/10",       // deprecated site-local
+)
+
+// parseCIDRs parses a list of CIDR blocks, panicking on invalid input
+func parseCIDRs(cidrs ...string) []*net.IPNet {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets
+}
+
+// IsReservedIP checks if the IP is in a reserved range (CGNAT, documentation, etc.)
+func IsReservedIP(ip string) bool {
+	return inNets(ip, reservedNets)
+}
+
+// inNets reports whether ip lies in any of nets
+func inNets(ip string, nets []*net.IPNet) bool {
+	parsedIP := net.ParseIP(strings.Trim(ip, "[]"))
+	if parsedIP == nil {
+		return false
+	}
+
+	for _, ipNet := range nets {
+		if ipNet.Contains(parsedIP) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseNetworks parses CIDR blocks and single IPs, which become
+// a /32 or /128 network
+func ParseNetworks(specs []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(specs))
+	for _, spec := range specs {
+		if !strings.Contains(spec, "/") {
+			parsedIP := net.ParseIP(spec)
+			if parsedIP == nil {
+				return nil, fmt.Errorf("invalid IP or CIDR: %s", spec)
+			}
+			bits := net.IPv6len * 8
+			if ip4 := parsedIP.To4(); ip4 != nil {
+				parsedIP, bits = ip4, net.IPv4len*8
+			}
+			nets = append(nets, &net.IPNet{IP: parsedIP, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, ipNet, err := net.ParseCIDR(spec)
+		if err != nil {
+			return nil, fmt.Errorf("invalid IP or CIDR: %s", spec)
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets, nil
+}
+
+// locationColor picks the annotation color: green for domestic, red for foreign
+func locationColor(domestic bool) string {
+	if domestic {
+		return colorGreen
+	}
+	return colorRed
+}
@@ -0,0 +1,376 @@
+package enrich
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var (
+	// Pre-compiled regular expressions for IP matching
+	ipv4Regex     *regexp.Regexp
+	ipv6Regex     *regexp.Regexp
+	ipv6BareRegex *regexp.Regexp
+	intIPRegex    *regexp.Regexp
+	ansiRegex     *regexp.Regexp
+)
+
+func init() {
+	// IPv4 pattern: simple dotted decimal format
+	ipv4Regex = regexp.MustCompile(`\b(?:\d{1,3}\.){3}\d{1,3}\b`)
+
+	// IPv6 pattern: only match bracket-enclosed format [xxxx:xxxx]
+	// This avoids false positives from port numbers (e.g., "pid:123")
+	// Dots let in embedded IPv4 ([::ffff:1.2.3.4]) and bracketed IPv4 ([1.2.3.4])
+	ipv6Regex = regexp.MustCompile(`\[([0-9a-fA-F:.]+)\]`)
+
+	// Bare IPv6 pattern: at least two colons, optionally ending in an embedded IPv4
+	// Candidates are validated with net.ParseIP since this also matches MACs and times
+	ipv6BareRegex = regexp.MustCompile(`(?:[0-9a-fA-F]{0,4}:){2,7}(?:\d{1,3}(?:\.\d{1,3}){3}|[0-9a-fA-F]{0,4})`)
+
+	// IPv4 written as one 32-bit integer, in decimal or 0x-prefixed hex
+	intIPRegex = regexp.MustCompile(`\b(?:0[xX][0-9a-fA-F]{1,8}|\d{8,10})\b`)
+
+	// ANSI escape sequences (CSI, e.g. "\x1b[31m") emitted by colored output
+	ansiRegex = regexp.MustCompile(`\x1b\[[0-?]*[ -/]*[@-~]`)
+}
+
+// Match stores matched IP address and its position in the string
+type Match struct {
+	IP      string // address without brackets, IPv6 in canonical form
+	Start   int    // start of the matched text, including brackets
+	End     int    // end of the matched text, including brackets
+	PortEnd int    // end of a trailing ":port", equal to End if none
+	Decoded bool   // IP was decoded from an integer like "3232235521"
+}
+
+// findAllIPs finds all IP addresses in a line with their positions,
+// ignoring ANSI escape sequences around and inside them
+func findAllIPs(line string) []Match {
+	return findIgnoringANSI(line, findPlainIPs)
+}
+
+// findIntIPs finds IPv4 addresses written as 32-bit integers, ignoring
+// ANSI escape sequences like findAllIPs
+func findIntIPs(line string) []Match {
+	return findIgnoringANSI(line, findPlainIntIPs)
+}
+
+// findIgnoringANSI runs find on line with ANSI escape sequences removed
+// and maps the positions of the matches back onto line
+func findIgnoringANSI(line string, find func(string) []Match) []Match {
+	if strings.IndexByte(line, '\x1b') < 0 {
+		return find(line)
+	}
+
+	// Match against the text without escapes, then map positions back
+	plain, offsets := stripANSI(line)
+	matches := find(plain)
+	for i := range matches {
+		matches[i].Start = offsets[matches[i].Start]
+		matches[i].End = offsets[matches[i].End-1] + 1
+		matches[i].PortEnd = offsets[matches[i].PortEnd-1] + 1
+	}
+
+	return matches
+}
+
+// stripANSI removes ANSI escape sequences from line, returning the plain text
+// and the offset in line of every byte of plain
+func stripANSI(line string) (string, []int) {
+	var plain strings.Builder
+	offsets := make([]int, 0, len(line))
+
+	last := 0
+	for _, loc := range ansiRegex.FindAllStringIndex(line, -1) {
+		plain.WriteString(line[last:loc[0]])
+		for i := last; i < loc[0]; i++ {
+			offsets = append(offsets, i)
+		}
+		last = loc[1]
+	}
+	plain.WriteString(line[last:])
+	for i := last; i < len(line); i++ {
+		offsets = append(offsets, i)
+	}
+
+	return plain.String(), offsets
+}
+
+// findPlainIPs finds all IP addresses in a line without escape sequences
+func findPlainIPs(line string) []Match {
+	matches := []Match{}
+
+	// Find IPv4 addresses
+	ipv4Matches := ipv4Regex.FindAllStringIndex(line, -1)
+	for _, match := range ipv4Matches {
+		ip := line[match[0]:match[1]]
+
+		// Discard candidates with out-of-range octets (e.g. "999.1.1.1"),
+		// parts of longer dotted runs (e.g. "1.2.3.4" in "1.2.3.4.5") and
+		// the tails of IPv6 text, which bare IPv6 matching has the say on
+		if net.ParseIP(ip) == nil || inDottedRun(line, match[0], match[1]) ||
+			strings.HasSuffix(line[:match[0]], "::") {
+			continue
+		}
+
+		matches = append(matches, Match{
+			IP:      ip,
+			Start:   match[0],
+			End:     match[1],
+			PortEnd: findPortEnd(line, match[1]),
+		})
+	}
+
+	// Find bracket-enclosed addresses, mostly IPv6; a bracketed IPv4 is
+	// matched brackets and all, so it's annotated once, after "]"
+	ipv6Matches := ipv6Regex.FindAllStringSubmatchIndex(line, -1)
+	for _, match := range ipv6Matches {
+		// match[0], match[1] is the full match [xxx]
+		// match[2], match[3] is the captured group (content inside brackets)
+
+		ip := line[match[2]:match[3]]
+		parsedIP := net.ParseIP(ip)
+		if parsedIP == nil {
+			// Not "[1.2.3]" or "[12:34]" either
+			continue
+		}
+		if strings.Contains(ip, ":") {
+			ip = parsedIP.String()
+		} else if parsedIP.To4() == nil {
+			continue
+		}
+		matches = append(matches, Match{
+			IP:      ip,
+			Start:   match[0],
+			End:     match[1],
+			PortEnd: findPortEnd(line, match[1]),
+		})
+	}
+
+	// Find bare IPv6 addresses (e.g. "2001:db8::1" in ping6 or ip -6 route output)
+	ipv6BareMatches := ipv6BareRegex.FindAllStringIndex(line, -1)
+	for _, match := range ipv6BareMatches {
+		if !isBareIPv6(line, match[0], match[1]) {
+			continue
+		}
+		matches = append(matches, Match{
+			IP:    canonicalIPv6(line[match[0]:match[1]]),
+			Start: match[0],
+			End:   match[1],
+			// A bare IPv6 ":port" can't be told apart from the address,
+			// but conntrack-style ".port" can
+			PortEnd: findDotPortEnd(line, match[1]),
+		})
+	}
+
+	return removeOverlaps(matches)
+}
+
+// findZeroPaddedIPs finds IPv4 addresses with zero-padded octets like
+// "192.168.001.001", ignoring ANSI escape sequences like findAllIPs
+func findZeroPaddedIPs(line string) []Match {
+	return findIgnoringANSI(line, findPlainZeroPaddedIPs)
+}
+
+// findPlainZeroPaddedIPs finds dotted quads that only parse once leading
+// zeros are stripped from their octets, matching them under the stripped
+// address; octets are read as decimal, so "010" is 10 rather than octal 8
+func findPlainZeroPaddedIPs(line string) []Match {
+	matches := []Match{}
+	for _, match := range ipv4Regex.FindAllStringIndex(line, -1) {
+		text := line[match[0]:match[1]]
+		if net.ParseIP(text) != nil || inDottedRun(line, match[0], match[1]) {
+			// Matched by findPlainIPs already, or no address
+			continue
+		}
+
+		octets := strings.Split(text, ".")
+		for i, octet := range octets {
+			if trimmed := strings.TrimLeft(octet, "0"); trimmed != "" {
+				octets[i] = trimmed
+			} else {
+				octets[i] = "0"
+			}
+		}
+		ip := strings.Join(octets, ".")
+		if net.ParseIP(ip) == nil {
+			continue
+		}
+
+		matches = append(matches, Match{
+			IP:      ip,
+			Start:   match[0],
+			End:     match[1],
+			PortEnd: findPortEnd(line, match[1]),
+		})
+	}
+	return matches
+}
+
+// findPlainIntIPs finds decimal and hex integers that decode to an IPv4
+// address of at least 1.0.0.0, which keeps small numbers out
+func findPlainIntIPs(line string) []Match {
+	matches := []Match{}
+	for _, match := range intIPRegex.FindAllStringIndex(line, -1) {
+		// Skip parts of longer tokens such as versions or decimals
+		if (match[0] > 0 && isAddrChar(line[match[0]-1])) ||
+			(match[1] < len(line) && line[match[1]] == '.') {
+			continue
+		}
+
+		text := line[match[0]:match[1]]
+		value, err := strconv.ParseUint(text, 0, 32)
+		if err != nil || value < 1<<24 {
+			continue
+		}
+
+		matches = append(matches, Match{
+			IP:      fmt.Sprintf("%d.%d.%d.%d", value>>24, value>>16&0xff, value>>8&0xff, value&0xff),
+			Start:   match[0],
+			End:     match[1],
+			PortEnd: findPortEnd(line, match[1]),
+			Decoded: true,
+		})
+	}
+	return matches
+}
+
+// canonicalIPv6 returns the compressed form of an IPv6 address, so that
+// e.g. "2001:db8:0:0:0:0:0:1" and "2001:db8::1" are looked up and
+// cached as one; text that doesn't parse is returned as-is
+func canonicalIPv6(ip string) string {
+	if parsedIP := net.ParseIP(ip); parsedIP != nil {
+		return parsedIP.String()
+	}
+	return ip
+}
+
+// findPortEnd returns the end of a ":port" (or ":*") suffix starting at pos,
+// or pos itself if the address has no port
+func findPortEnd(line string, pos int) int {
+	if pos >= len(line) || line[pos] != ':' {
+		return pos
+	}
+
+	end := pos + 1
+	if end < len(line) && line[end] == '*' {
+		end++
+	} else {
+		for end < len(line) && end-pos <= 5 && line[end] >= '0' && line[end] <= '9' {
+			end++
+		}
+	}
+
+	// Require a complete port token, not the start of something longer
+	if end == pos+1 || (end < len(line) && isAddrChar(line[end])) {
+		return pos
+	}
+	return end
+}
+
+// findDotPortEnd returns the end of a ".port" suffix starting at pos, as
+// in conntrack's "2001:db8::1.443", or pos itself if there is none
+func findDotPortEnd(line string, pos int) int {
+	if pos >= len(line) || line[pos] != '.' {
+		return pos
+	}
+
+	end := pos + 1
+	for end < len(line) && end-pos <= 5 && line[end] >= '0' && line[end] <= '9' {
+		end++
+	}
+
+	// Require a complete port token, e.g. not the start of an IPv4 part
+	if end == pos+1 || (end < len(line) && (isAddrChar(line[end]) || line[end] == '.')) {
+		return pos
+	}
+	if port, err := strconv.Atoi(line[pos+1 : end]); err != nil || port > 65535 {
+		return pos
+	}
+	return end
+}
+
+// isBareIPv6 checks if line[start:end] is a standalone, valid IPv6 address
+func isBareIPv6(line string, start, end int) bool {
+	// Reject candidates glued to surrounding words (e.g. "std::vector", "abcde:1::2")
+	if start > 0 && isAddrChar(line[start-1]) {
+		return false
+	}
+	if end < len(line) && isAddrChar(line[end]) && findDotPortEnd(line, end) == end &&
+		!endsSentence(line, end) {
+		return false
+	}
+
+	// Only accept candidates that parse as a 16-byte IPv6 address,
+	// which rules out MAC addresses and "pid:123"-style tokens
+	parsedIP := net.ParseIP(line[start:end])
+	return parsedIP != nil && parsedIP.To4() == nil && len(parsedIP) == net.IPv6len
+}
+
+// endsSentence reports whether line[pos] is a full stop ending the
+// text or followed by a space, as after "via ::ffff:10.0.0.1."
+func endsSentence(line string, pos int) bool {
+	return line[pos] == '.' && (pos+1 == len(line) || line[pos+1] == ' ' || line[pos+1] == '\t')
+}
+
+// inDottedRun reports whether line[start:end] is part of a longer run of
+// dotted numbers, like a version or OID, which no IPv4 address is
+func inDottedRun(line string, start, end int) bool {
+	return (start >= 2 && line[start-1] == '.' && isDigit(line[start-2])) ||
+		(end+1 < len(line) && line[end] == '.' && isDigit(line[end+1]))
+}
+
+// isAddrChar reports whether c can be part of an IP address token
+func isAddrChar(c byte) bool {
+	return c == ':' || c == '.' || c == '_' ||
+		(c >= '0' && c <= '9') || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+// removeOverlaps drops matches that overlap an earlier, longer match
+// so the same address is never annotated twice (e.g. bracketed and bare IPv6)
+func removeOverlaps(matches []Match) []Match {
+	if len(matches) < 2 {
+		return matches
+	}
+
+	// Sort by start position, longest match first on ties
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].Start != matches[j].Start {
+			return matches[i].Start < matches[j].Start
+		}
+		return matches[i].End > matches[j].End
+	})
+
+	result := matches[:1]
+	for _, match := range matches[1:] {
+		last := &result[len(result)-1]
+		if match.Start >= last.End {
+			result = append(result, match)
+			continue
+		}
+		// Overlapping: keep whichever covers more of the line
+		if match.End-match.Start > last.End-last.Start {
+			*last = match
+		}
+	}
+
+	return result
+}
+
+// inURL reports whether the match is the host of a URL, i.e. preceded by
+// "://" or followed by a path (but not a CIDR suffix like "/24")
+func inURL(line string, match Match) bool {
+	if match.Start >= 3 && line[match.Start-3:match.Start] == "://" {
+		return true
+	}
+
+	end := match.PortEnd
+	if end < len(line) && line[end] == '/' {
+		return end+1 >= len(line) || line[end+1] < '0' || line[end+1] > '9'
+	}
+	return false
+}
@@ -0,0 +1,60 @@
+package enrich
+
+import (
+	"net"
+	"strings"
+	"testing"
+)
+
+// matchSeeds are lines with the shapes findAllIPs has to get right
+var matchSeeds = []string{
+	"",
+	"Accepted publickey for root from 192.168.1.10 port 22 ssh2",
+	"1.2.3.4.5",
+	"version 10.2.3.4.5.6.7.8 released",
+	"connect to 8.8.8.8:53 and [2001:db8::1]:443 via ::ffff:10.0.0.1.",
+	"2001:db8::1.443 fe80::1%eth0 std::vector 12:34:56",
+	"\x1b[31m10.0.\x1b[0m0.1\x1b[0m [1.2.3.4] [::1]",
+	"[[[[1.2.3.4]]]] ....... 1..2..3..4 999.1.1.1",
+	"X-Forwarded-For: 8.8.8.8, 1.1.1.1:443 9.9.9.9 [note] (eth0)",
+	strings.Repeat(".", 1000) + "1.1.1.1" + strings.Repeat(":", 1000),
+}
+
+// checkMatches fails t unless matches are in order, within line and
+// apart, and each is a valid address that isn't part of a longer token
+func checkMatches(t *testing.T, line string, matches []Match) {
+	t.Helper()
+	last := 0
+	for _, m := range matches {
+		if m.Start < last || m.End <= m.Start || m.PortEnd < m.End || m.PortEnd > len(line) {
+			t.Fatalf("match %+v out of order or bounds (previous end %d) in %q", m, last, line)
+		}
+		last = m.End
+		if net.ParseIP(m.IP) == nil {
+			t.Fatalf("match %+v is not an IP in %q", m, line)
+		}
+		if strings.IndexByte(line, '\x1b') < 0 && !strings.Contains(m.IP, ":") && inDottedRun(line, m.Start, m.End) {
+			t.Fatalf("match %+v is part of a dotted run in %q", m, line)
+		}
+	}
+}
+
+func FuzzFindAllIPs(f *testing.F) {
+	for _, seed := range matchSeeds {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, line string) {
+		checkMatches(t, line, findAllIPs(line))
+	})
+}
+
+func TestFindAllIPsDottedRun(t *testing.T) {
+	for _, line := range []string{"1.2.3.4.5", "0.1.2.3.4", "a 1.2.3.4.5.6.7.8 b"} {
+		if matches := findAllIPs(line); len(matches) != 0 {
+			t.Errorf("findAllIPs(%q) = %+v, want none", line, matches)
+		}
+	}
+	if matches := findAllIPs("from 1.2.3.4."); len(matches) != 1 || matches[0].IP != "1.2.3.4" {
+		t.Errorf("findAllIPs at the end of a sentence = %+v, want 1.2.3.4", matches)
+	}
+}
@@ -0,0 +1,178 @@
+package enrich
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"path/filepath"
+	"strings"
+
+	"github.com/oschwald/maxminddb-golang"
+	"github.com/xiaoqidun/qqwry"
+)
+
+// Languages tried in order when picking MaxMind place names; Chinese
+// comes first so results read the same as qqwry's (e.g. "中国")
+var maxmindLanguages = []string{"zh-CN", "en"}
+
+// MaxMind is a loaded MaxMind GeoIP2/GeoLite2 City or Country database
+type MaxMind struct {
+	path   string
+	reader *maxminddb.Reader
+}
+
+// maxmindRecord holds the fields of a MaxMind city record we use
+type maxmindRecord struct {
+	Country struct {
+		Names map[string]string `maxminddb:"names"`
+	} `maxminddb:"country"`
+	Subdivisions []struct {
+		Names map[string]string `maxminddb:"names"`
+	} `maxminddb:"subdivisions"`
+	City struct {
+		Names map[string]string `maxminddb:"names"`
+	} `maxminddb:"city"`
+}
+
+// maxmindLocation holds the coordinates of a MaxMind city record
+type maxmindLocation struct {
+	Location struct {
+		Latitude  *float64 `maxminddb:"latitude"`
+		Longitude *float64 `maxminddb:"longitude"`
+	} `maxminddb:"location"`
+}
+
+// OpenMaxMind loads the MaxMind .mmdb database at path
+func OpenMaxMind(path string) (*MaxMind, error) {
+	reader, err := openMMDB(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load MaxMind database: %w", err)
+	}
+	return &MaxMind{path: path, reader: reader}, nil
+}
+
+// openMMDB opens an .mmdb file, memory-mapped unless it is gzipped
+// (named ".gz"), in which case it is decompressed into memory
+func openMMDB(path string) (*maxminddb.Reader, error) {
+	if !strings.EqualFold(filepath.Ext(path), GzipExt) {
+		return maxminddb.Open(path)
+	}
+	data, err := readDatabase(path)
+	if err != nil {
+		return nil, err
+	}
+	return maxminddb.FromBytes(data)
+}
+
+// Path returns the file the database was loaded from
+func (d *MaxMind) Path() string {
+	return d.path
+}
+
+// Close releases the database
+func (d *MaxMind) Close() error {
+	return d.reader.Close()
+}
+
+// Query looks up the location of an IP address, mapping the MaxMind
+// country, first subdivision and city onto Country, Province and City
+func (d *MaxMind) Query(ip string) (*qqwry.Location, error) {
+	parsedIP := net.ParseIP(ip)
+	if parsedIP == nil {
+		return nil, errors.New("invalid ip")
+	}
+
+	var record maxmindRecord
+	if err := d.reader.Lookup(parsedIP, &record); err != nil {
+		return nil, err
+	}
+
+	loc := &qqwry.Location{
+		IP:      ip,
+		Country: maxmindName(record.Country.Names),
+		City:    maxmindName(record.City.Names),
+	}
+	if len(record.Subdivisions) > 0 {
+		loc.Province = maxmindName(record.Subdivisions[0].Names)
+	}
+	if loc.Country == "" && loc.Province == "" && loc.City == "" {
+		return nil, ErrNotFound
+	}
+
+	return loc, nil
+}
+
+// QueryCoordinates looks up the approximate position of an IP address,
+// which Country databases don't have
+func (d *MaxMind) QueryCoordinates(ip string) (Coordinates, bool) {
+	parsedIP := net.ParseIP(ip)
+	if parsedIP == nil {
+		return Coordinates{}, false
+	}
+
+	var record maxmindLocation
+	if err := d.reader.Lookup(parsedIP, &record); err != nil ||
+		record.Location.Latitude == nil || record.Location.Longitude == nil {
+		return Coordinates{}, false
+	}
+	return Coordinates{Lat: *record.Location.Latitude, Lon: *record.Location.Longitude}, true
+}
+
+// maxmindName picks a place name in the preferred language
+func maxmindName(names map[string]string) string {
+	for _, lang := range maxmindLanguages {
+		if name := names[lang]; name != "" {
+			return name
+		}
+	}
+	return ""
+}
+
+// ASNBackend looks up the autonomous system of IP addresses
+type ASNBackend interface {
+	// QueryASN returns the AS number and organization of ip
+	QueryASN(ip string) (uint, string, error)
+}
+
+// MaxMindASN is a loaded MaxMind GeoLite2-ASN database
+type MaxMindASN struct {
+	path   string
+	reader *maxminddb.Reader
+}
+
+// maxmindASNRecord holds the fields of a MaxMind ASN record
+type maxmindASNRecord struct {
+	Number       uint   `maxminddb:"autonomous_system_number"`
+	Organization string `maxminddb:"autonomous_system_organization"`
+}
+
+// OpenMaxMindASN loads the MaxMind ASN .mmdb database at path
+func OpenMaxMindASN(path string) (*MaxMindASN, error) {
+	reader, err := openMMDB(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load ASN database: %w", err)
+	}
+	return &MaxMindASN{path: path, reader: reader}, nil
+}
+
+// Close releases the database
+func (d *MaxMindASN) Close() error {
+	return d.reader.Close()
+}
+
+// QueryASN looks up the autonomous system of an IP address
+func (d *MaxMindASN) QueryASN(ip string) (uint, string, error) {
+	parsedIP := net.ParseIP(ip)
+	if parsedIP == nil {
+		return 0, "", errors.New("invalid ip")
+	}
+
+	var record maxmindASNRecord
+	if err := d.reader.Lookup(parsedIP, &record); err != nil {
+		return 0, "", err
+	}
+	if record.Number == 0 {
+		return 0, "", ErrNotFound
+	}
+	return record.Number, record.Organization, nil
+}
@@ -0,0 +1,67 @@
+package enrich
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+)
+
+// Overrides maps IPs and networks to custom labels that take the place
+// of database results, e.g. names of your own servers
+type Overrides struct {
+	entries []override
+}
+
+// override is one network of an Overrides file and its label
+type override struct {
+	network *net.IPNet
+	label   string
+}
+
+// ParseOverrides reads lines of "ip=label" or "cidr=label" ("=>" works
+// too, and the label may be quoted); blank lines and "#" comments are
+// ignored
+func ParseOverrides(r io.Reader) (*Overrides, error) {
+	overrides := &Overrides{}
+	scanner := bufio.NewScanner(r)
+	for number := 1; scanner.Scan(); number++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		spec, label, ok := strings.Cut(line, "=")
+		label = strings.TrimPrefix(label, ">")
+		label = strings.Trim(strings.TrimSpace(label), `"`)
+		if !ok || label == "" {
+			return nil, fmt.Errorf("line %d: expected ip=label or cidr=label", number)
+		}
+		nets, err := ParseNetworks([]string{strings.TrimSpace(spec)})
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", number, err)
+		}
+		overrides.entries = append(overrides.entries, override{network: nets[0], label: label})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read overrides: %w", err)
+	}
+	return overrides, nil
+}
+
+// Lookup returns the label of the most specific network containing ip
+func (o *Overrides) Lookup(ip string) (string, bool) {
+	parsedIP := net.ParseIP(strings.Trim(ip, "[]"))
+	if o == nil || parsedIP == nil {
+		return "", false
+	}
+
+	label, best := "", -1
+	for _, entry := range o.entries {
+		if ones, _ := entry.network.Mask.Size(); ones > best && entry.network.Contains(parsedIP) {
+			label, best = entry.label, ones
+		}
+	}
+	return label, best >= 0
+}
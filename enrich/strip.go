@@ -0,0 +1,309 @@
+package enrich
+
+import (
+	"strings"
+)
+
+// maxStripLabel is the longest label Strip removes, keeping it from
+// reaching into unrelated text far along the line
+const maxStripLabel = 256
+
+// Places Strip looks for annotations, as Options.Placement and
+// Options.AfterPort put them
+const (
+	stripAfter     = iota // right after the IP
+	stripAfterPort        // after the port of the IP following a space, if it has one
+	stripBefore           // before the IP, followed by a space
+)
+
+// Strip removes annotations in the template's format that ip-plus put
+// next to IPs earlier, so already enriched text can be enriched afresh
+//
+// Only annotations ip-plus could have written are removed: the location
+// this enricher finds for the IP, with or without a flag and extras like
+// the time zone, or one of its fixed labels such as "Unknown", so
+// "10.0.0.1(eth0)" stays as it is. Annotations after, before or after the
+// port of an IP are all found, along with their color and highlighting,
+// and with GroupLists the bracketed labels of a list of IPs. Locations
+// come from the lookup cache or the database alone, so stripping runs no
+// lookup command or reverse DNS query.
+func (e *Enricher) Strip(line string) string {
+	if !e.template.Wrapped() {
+		return line
+	}
+
+	// A line was annotated one way throughout, so take the place that
+	// accounts for the most annotations, this enricher's own on a tie
+	places := []int{stripAfter, stripAfterPort, stripBefore}
+	switch {
+	case e.opts.Placement == PlacementBefore:
+		places = []int{stripBefore, stripAfter, stripAfterPort}
+	case e.opts.AfterPort:
+		places = []int{stripAfterPort, stripAfter, stripBefore}
+	}
+
+	matches := e.findIPs(line)
+	var best []splice
+	for _, place := range places {
+		if splices := e.stripSplices(line, matches, place); len(splices) > len(best) {
+			best = splices
+		}
+	}
+	return applySplices(line, best)
+}
+
+// stripSplices returns the splices removing the annotations in place of
+// the IPs that have one
+func (e *Enricher) stripSplices(line string, matches []Match, place int) []splice {
+	var splices []splice
+	last := 0
+	add := func(s splice) {
+		if s.start >= last {
+			splices = append(splices, s)
+			last = s.end
+		}
+	}
+	for i := 0; i < len(matches); i++ {
+		if e.opts.GroupLists {
+			if s, n, ok := e.stripGroup(line, matches[i:], place); ok {
+				add(s)
+				i += n - 1
+				continue
+			}
+		}
+		if s, ok := e.stripSplice(line, matches[i], place); ok {
+			add(s)
+		}
+	}
+	return splices
+}
+
+// stripSplice returns the splice removing the annotation in place of
+// match from line, if it has one
+func (e *Enricher) stripSplice(line string, match Match, place int) (splice, bool) {
+	result := Result{Match: match, Lookup: e.stripLookup(match.IP)}
+	// Highlighting wraps the IP in escapes the match leaves out, or
+	// takes in when annotating made a longer address of the text around
+	ip := strings.ReplaceAll(line[match.Start:match.End], highlightStart, "")
+	start, ipEnd := match.Start, match.End
+	if strings.HasSuffix(line[:start], highlightStart) && strings.HasPrefix(line[ipEnd:], highlightEnd) {
+		start -= len(highlightStart)
+		ipEnd += len(highlightEnd)
+	}
+
+	switch {
+	case place == stripBefore:
+		if begin, ok := e.annotationBefore(line[:start], result); ok {
+			return splice{begin, ipEnd, ip}, true
+		}
+	case place == stripAfterPort && match.PortEnd > ipEnd:
+		if strings.HasPrefix(line[match.PortEnd:], " ") {
+			if end, ok := e.annotationAfter(line, match.PortEnd+1, result); ok {
+				return splice{start, end, ip + line[ipEnd:match.PortEnd]}, true
+			}
+		}
+	default:
+		if end, ok := e.annotationAfter(line, ipEnd, result); ok {
+			return splice{start, end, ip}, true
+		}
+	}
+	return splice{}, false
+}
+
+// stripGroup returns the splice removing the bracketed labels GroupLists
+// puts next to the list of IPs starting at matches[0], and the number of
+// IPs in the list, if it is one and has them
+func (e *Enricher) stripGroup(line string, matches []Match, place int) (splice, int, bool) {
+	n := 1
+	for n < len(matches) && isListSeparator(plainText(line[matches[n-1].PortEnd:matches[n].Start])) {
+		n++
+	}
+	if n == 1 {
+		return splice{}, 0, false
+	}
+	group := make([]Result, n)
+	for i, match := range matches[:n] {
+		group[i] = Result{Match: match, Lookup: e.stripLookup(match.IP)}
+	}
+
+	// The list with the highlighting of its IPs taken out
+	start, end := matches[0].Start, matches[n-1].PortEnd
+	if strings.HasSuffix(line[:start], highlightStart) {
+		start -= len(highlightStart)
+	}
+	if strings.HasPrefix(line[end:], highlightEnd) {
+		end += len(highlightEnd)
+	}
+	list := strings.NewReplacer(highlightStart, "", highlightEnd, "").Replace(line[start:end])
+
+	if place == stripBefore {
+		text := line[:start]
+		if !strings.HasSuffix(text, "] ") {
+			return splice{}, 0, false
+		}
+		// The last "[" that doesn't start an escape sequence
+		open := strings.LastIndexByte(text[:len(text)-2], '[')
+		for open > 0 && text[open-1] == '\x1b' {
+			open = strings.LastIndexByte(text[:open-1], '[')
+		}
+		if open < 0 || !e.groupLabels(text[open+1:len(text)-2], group) {
+			return splice{}, 0, false
+		}
+		return splice{open, end, list}, n, true
+	}
+
+	rest := line[end:]
+	if !strings.HasPrefix(rest, " [") {
+		return splice{}, 0, false
+	}
+	closing := strings.IndexByte(rest[2:], ']')
+	if closing < 0 || !e.groupLabels(rest[2:2+closing], group) {
+		return splice{}, 0, false
+	}
+	return splice{start, end + 2 + closing + 1, list}, n, true
+}
+
+// groupLabels reports whether text between brackets holds the labels
+// ip-plus gives the IPs of group in turn, colors included
+func (e *Enricher) groupLabels(text string, group []Result) bool {
+	text = plainText(text)
+	if len(text) > maxStripLabel*len(group) || strings.ContainsRune(text, '[') {
+		return false
+	}
+	return e.ownLabels(strings.Split(text, ", "), group)
+}
+
+// ownLabels reports whether parts, the ", " separated pieces of a group
+// annotation, are the labels of group in turn; as a label can hold ", "
+// itself, each takes one or more pieces
+func (e *Enricher) ownLabels(parts []string, group []Result) bool {
+	// ends[i] is set when the first i parts are the labels of a prefix of
+	// group, as far as the last result handled
+	ends := make([]bool, len(parts)+1)
+	ends[0] = true
+	for _, result := range group {
+		next := make([]bool, len(parts)+1)
+		for start, ok := range ends {
+			if !ok {
+				continue
+			}
+			label := ""
+			for end := start + 1; end <= len(parts) && len(label) <= maxStripLabel; end++ {
+				if end > start+1 {
+					label += ", "
+				}
+				label += parts[end-1]
+				if e.ownLabel(label, result) {
+					next[end] = true
+				}
+			}
+		}
+		ends = next
+	}
+	return ends[len(parts)]
+}
+
+// annotationAfter returns the end of an annotation of result starting at
+// pos in line, colors included
+func (e *Enricher) annotationAfter(line string, pos int, result Result) (int, bool) {
+	rest := line[pos:]
+	trimmed := trimLeadingANSI(rest)
+	colored := len(trimmed) < len(rest)
+	if !strings.HasPrefix(trimmed, e.template.prefix) {
+		return 0, false
+	}
+
+	body := trimmed[len(e.template.prefix):]
+	n := strings.Index(body, e.template.suffix)
+	if n < 0 || !e.ownLabel(body[:n], result) {
+		return 0, false
+	}
+	end := len(line) - len(body) + n + len(e.template.suffix)
+	if colored && strings.HasPrefix(line[end:], colorReset) {
+		end += len(colorReset)
+	}
+	return end, true
+}
+
+// annotationBefore returns the start of an annotation of result that
+// ends text with a space, colors included
+func (e *Enricher) annotationBefore(text string, result Result) (int, bool) {
+	if !strings.HasSuffix(text, " ") {
+		return 0, false
+	}
+	text = strings.TrimSuffix(text, " ")
+	colored := strings.HasSuffix(text, colorReset)
+	text = strings.TrimSuffix(text, colorReset)
+	if !strings.HasSuffix(text, e.template.suffix) {
+		return 0, false
+	}
+
+	body := strings.TrimSuffix(text, e.template.suffix)
+	n := strings.LastIndex(body, e.template.prefix)
+	if n < 0 || !e.ownLabel(body[n+len(e.template.prefix):], result) {
+		return 0, false
+	}
+	begin := n
+	if colored {
+		// The color code right before the prefix
+		if loc := ansiRegex.FindAllStringIndex(body[:n], -1); len(loc) > 0 && loc[len(loc)-1][1] == n {
+			begin = loc[len(loc)-1][0]
+		}
+	}
+	return begin, true
+}
+
+// ownLabel reports whether label is one ip-plus gives result: its
+// current label, or a fixed one like "Unknown" or "LookupError: ..."
+func (e *Enricher) ownLabel(label string, result Result) bool {
+	label = strings.TrimRight(label, " ")
+	if label == "" || len(label) > maxStripLabel || strings.Contains(label, e.template.prefix) {
+		return false
+	}
+	switch label {
+	case e.label(result), "〃", "-":
+		return true
+	case "Local", "Reserved":
+		return result.Special
+	case "Unknown", "NotFound", "LookupError", timedOutLookup.Label:
+		return result.Location == nil && !result.Special
+	}
+	if strings.HasPrefix(label, "LookupError: ") {
+		return result.Location == nil && !result.Special
+	}
+
+	// The location, whatever flag and extras other options added to it
+	location, label := trimFlag(result.Label), trimFlag(label)
+	return label == location || strings.HasPrefix(label, location+", ") || strings.HasPrefix(label, location+" / ")
+}
+
+// trimFlag removes a leading flag emoji and its space from label
+func trimFlag(label string) string {
+	runes := []rune(label)
+	if len(runes) > 3 && isRegionalIndicator(runes[0]) && isRegionalIndicator(runes[1]) && runes[2] == ' ' {
+		return string(runes[3:])
+	}
+	return label
+}
+
+// isRegionalIndicator reports whether r is one of the letters flag
+// emoji are made of
+func isRegionalIndicator(r rune) bool {
+	return r >= 0x1F1E6 && r <= 0x1F1FF
+}
+
+// plainText returns text with its escape sequences removed
+func plainText(text string) string {
+	return ansiRegex.ReplaceAllString(text, "")
+}
+
+// trimLeadingANSI removes the escape sequences text starts with
+func trimLeadingANSI(text string) string {
+	for {
+		loc := ansiRegex.FindStringIndex(text)
+		if loc == nil || loc[0] != 0 {
+			return text
+		}
+		text = text[loc[1]:]
+	}
+}
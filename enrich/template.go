@@ -0,0 +1,125 @@
+package enrich
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/xiaoqidun/qqwry"
+)
+
+// DefaultFormat is the annotation template matching the original output
+const DefaultFormat = "({province}{city}{district}{isp})"
+
+// Placeholders maps template placeholders to qqwry.Location fields
+var Placeholders = map[string]func(loc *qqwry.Location) string{
+	"country":  func(loc *qqwry.Location) string { return loc.Country },
+	"province": func(loc *qqwry.Location) string { return loc.Province },
+	"city":     func(loc *qqwry.Location) string { return loc.City },
+	"district": func(loc *qqwry.Location) string { return loc.District },
+	"isp":      func(loc *qqwry.Location) string { return loc.ISP },
+}
+
+// Template renders annotations from a format like "{country}/{province}/{city}"
+//
+// Text before the first placeholder and after the last one is kept as-is,
+// text between placeholders acts as a separator and is only emitted between
+// two non-empty fields, so missing fields never leave stray separators.
+type Template struct {
+	prefix     string
+	suffix     string
+	fields     []string // placeholder names in order
+	separators []string // separators[i] precedes fields[i+1]
+
+	sep   string // used between fields the format puts no text between
+	dedup bool   // skip fields equal to the field before, e.g. 北京北京
+}
+
+// ParseTemplate parses an annotation format template
+func ParseTemplate(format string) (*Template, error) {
+	t := &Template{}
+	literal := ""
+	rest := format
+
+	for {
+		open := strings.IndexByte(rest, '{')
+		if open < 0 {
+			break
+		}
+		end := strings.IndexByte(rest[open:], '}')
+		if end < 0 {
+			return nil, fmt.Errorf("unclosed placeholder in format: %s", format)
+		}
+		name := rest[open+1 : open+end]
+		if _, ok := Placeholders[name]; !ok {
+			return nil, fmt.Errorf("unknown placeholder {%s} in format: %s", name, format)
+		}
+
+		literal += rest[:open]
+		if len(t.fields) == 0 {
+			t.prefix = literal
+		} else {
+			t.separators = append(t.separators, literal)
+		}
+		t.fields = append(t.fields, name)
+		literal = ""
+		rest = rest[open+end+1:]
+	}
+
+	if len(t.fields) == 0 {
+		return nil, fmt.Errorf("format has no placeholders: %s", format)
+	}
+	t.suffix = literal + rest
+
+	return t, nil
+}
+
+// Location formats the fields of loc, returning "Unknown" if all are empty
+func (t *Template) Location(loc *qqwry.Location) string {
+	if loc == nil {
+		return "Unknown"
+	}
+
+	var b strings.Builder
+	last := ""
+	for i, name := range t.fields {
+		value := Placeholders[name](loc)
+		if value == "" || value == "0" || (t.dedup && value == last) {
+			continue
+		}
+		if b.Len() > 0 {
+			sep := t.separators[i-1]
+			if sep == "" {
+				sep = t.sep
+			}
+			b.WriteString(sep)
+		}
+		b.WriteString(value)
+		last = value
+	}
+
+	if b.Len() == 0 {
+		return "Unknown"
+	}
+	return b.String()
+}
+
+// Wrap surrounds a location label with the template's prefix and suffix
+func (t *Template) Wrap(label string) string {
+	return t.prefix + label + t.suffix
+}
+
+// Wrapped reports whether the template puts text both before and after
+// the location, so annotations can be told apart from what surrounds them
+func (t *Template) Wrapped() bool {
+	return t.prefix != "" && t.suffix != ""
+}
+
+// Has reports whether the template uses the named placeholder
+func (t *Template) Has(name string) bool {
+	for _, field := range t.fields {
+		if field == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,50 @@
+package enrich
+
+import (
+	"time"
+)
+
+// pendingLookup is a lookup running in the background for lookupWithin
+type pendingLookup struct {
+	done   chan struct{} // closed once result is set
+	result Lookup
+}
+
+// timedOutLookup stands in for a lookup that took longer than
+// Options.LookupTimeout; like an unknown location it counts as foreign,
+// so filters such as --foreign-only keep the line rather than hide it
+var timedOutLookup = Lookup{Label: "Timeout", foreign: true, color: colorGray}
+
+// lookupWithin is lookup bounded by Options.LookupTimeout: a lookup that
+// takes longer, e.g. a hung lookup command or reverse DNS, yields
+// timedOutLookup but carries on, so later lines find its result cached
+func (e *Enricher) lookupWithin(ip string) Lookup {
+	key := normalizeIP(ip)
+	if e.opts.LookupTimeout <= 0 {
+		return e.lookup(ip)
+	}
+	if _, ok := e.cache.Load(key); ok {
+		return e.lookup(ip)
+	}
+
+	// Lines waiting on the same IP share one lookup
+	value, running := e.pending.LoadOrStore(key, &pendingLookup{done: make(chan struct{})})
+	pending := value.(*pendingLookup)
+	if !running {
+		go func() {
+			pending.result = e.lookup(ip)
+			e.pending.Delete(key)
+			close(pending.done)
+		}()
+	}
+
+	timer := time.NewTimer(e.opts.LookupTimeout)
+	defer timer.Stop()
+	select {
+	case <-pending.done:
+		return pending.result
+	case <-timer.C:
+		e.opts.Logger.Debug("lookup timed out", "ip", ip, "timeout", e.opts.LookupTimeout)
+		return timedOutLookup
+	}
+}
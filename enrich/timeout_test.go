@@ -0,0 +1,30 @@
+package enrich
+
+import (
+	"testing"
+	"time"
+
+	"github.com/xiaoqidun/qqwry"
+)
+
+// slowBackend is fakeBackend taking its time over each query
+type slowBackend struct {
+	fakeBackend
+	delay time.Duration
+}
+
+func (b slowBackend) Query(ip string) (*qqwry.Location, error) {
+	time.Sleep(b.delay)
+	return b.fakeBackend.Query(ip)
+}
+
+func TestTimedOutLookupIsForeign(t *testing.T) {
+	e, err := New(slowBackend{delay: 200 * time.Millisecond}, Options{LookupTimeout: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	results := e.Resolve("from 8.8.8.8")
+	if len(results) != 1 || results[0].Label != timedOutLookup.Label || !results[0].IsForeign() {
+		t.Errorf("Resolve = %+v, want one foreign %q result", results, timedOutLookup.Label)
+	}
+}
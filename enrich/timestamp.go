@@ -0,0 +1,62 @@
+package enrich
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	// Dates and times right before a match, e.g. "2024-10-24 " or "12:00:01 "
+	dateTimeBeforeRegex = regexp.MustCompile(`(?:\d{4}[-/.]\d{1,2}[-/.]\d{1,2}|\d{1,2}[-/]\d{1,2}[-/]\d{2,4}|\d{1,2}:\d{2}(?::\d{2})?|(?i:jan|feb|mar|apr|may|jun|jul|aug|sep|oct|nov|dec)[a-z]*\.?)[ T_@-]?$`)
+
+	// Times and zones right after a match, e.g. " 12:00" or " +0800"
+	dateTimeAfterRegex = regexp.MustCompile(`^(?:[ T_]?\d{1,2}:\d{2}|[ ]?[+-]\d{2}:?\d{2}\b|[ ]?(?:UTC|GMT|Z)\b)`)
+)
+
+// timestampLike reports whether an IPv4 match is more likely part of a
+// timestamp: a day.month.hour.minute or hour.minute.second.fraction shape
+// next to a date or time, as in "2024-10-24 10.24.15.30"; the tails of
+// longer dotted runs like "2024.10.24.15.30" are never matched at all
+func timestampLike(line string, match Match) bool {
+	if match.Decoded || strings.IndexByte(match.IP, ':') >= 0 {
+		return false
+	}
+
+	if !timestampShape(line[match.Start:match.End]) {
+		return false
+	}
+	return dateTimeBeforeRegex.MatchString(line[:match.Start]) ||
+		dateTimeAfterRegex.MatchString(line[match.End:])
+}
+
+// timestampShape reports whether a dotted quad reads as day.month.hour.minute
+// (either order of day and month) or hour.minute.second.fraction, with the
+// minutes and seconds zero-padded to two digits as clocks print them
+func timestampShape(text string) bool {
+	fields := strings.Split(text, ".")
+	if len(fields) != 4 {
+		return false
+	}
+	values := make([]int, len(fields))
+	for i, field := range fields {
+		value, err := strconv.Atoi(field)
+		if err != nil {
+			return false
+		}
+		values[i] = value
+	}
+	padded := func(i int) bool { return len(fields[i]) == 2 }
+
+	isDate := func(day, month int) bool { return day >= 1 && day <= 31 && month >= 1 && month <= 12 }
+	if (isDate(values[0], values[1]) || isDate(values[1], values[0])) &&
+		values[2] <= 23 && values[3] <= 59 && padded(3) {
+		return true
+	}
+	return values[0] <= 23 && values[1] <= 59 && values[2] <= 59 && padded(1) && padded(2)
+}
+
+// isDigit reports whether c is an ASCII digit
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
@@ -0,0 +1,121 @@
+package enrich
+
+import (
+	"fmt"
+	"time"
+)
+
+// countryZone is the IANA time zone of a country's capital
+type countryZone struct {
+	name   string
+	approx bool // the country spans several zones
+}
+
+// countryZones maps ISO country codes to the zone of their capital,
+// which stands in for the whole country
+var countryZones = map[string]countryZone{
+	"CN": {"Asia/Shanghai", false},
+	"HK": {"Asia/Hong_Kong", false},
+	"MO": {"Asia/Macau", false},
+	"TW": {"Asia/Taipei", false},
+	"US": {"America/New_York", true},
+	"CA": {"America/Toronto", true},
+	"MX": {"America/Mexico_City", true},
+	"BR": {"America/Sao_Paulo", true},
+	"AR": {"America/Argentina/Buenos_Aires", false},
+	"CL": {"America/Santiago", true},
+	"CO": {"America/Bogota", false},
+	"PE": {"America/Lima", false},
+	"GB": {"Europe/London", false},
+	"IE": {"Europe/Dublin", false},
+	"FR": {"Europe/Paris", false},
+	"DE": {"Europe/Berlin", false},
+	"NL": {"Europe/Amsterdam", false},
+	"BE": {"Europe/Brussels", false},
+	"LU": {"Europe/Luxembourg", false},
+	"CH": {"Europe/Zurich", false},
+	"AT": {"Europe/Vienna", false},
+	"IT": {"Europe/Rome", false},
+	"ES": {"Europe/Madrid", true},
+	"PT": {"Europe/Lisbon", true},
+	"SE": {"Europe/Stockholm", false},
+	"NO": {"Europe/Oslo", false},
+	"DK": {"Europe/Copenhagen", false},
+	"FI": {"Europe/Helsinki", false},
+	"IS": {"Atlantic/Reykjavik", false},
+	"PL": {"Europe/Warsaw", false},
+	"CZ": {"Europe/Prague", false},
+	"SK": {"Europe/Bratislava", false},
+	"HU": {"Europe/Budapest", false},
+	"RO": {"Europe/Bucharest", false},
+	"BG": {"Europe/Sofia", false},
+	"GR": {"Europe/Athens", false},
+	"UA": {"Europe/Kyiv", false},
+	"BY": {"Europe/Minsk", false},
+	"RU": {"Europe/Moscow", true},
+	"TR": {"Europe/Istanbul", false},
+	"IL": {"Asia/Jerusalem", false},
+	"SA": {"Asia/Riyadh", false},
+	"AE": {"Asia/Dubai", false},
+	"IR": {"Asia/Tehran", false},
+	"IQ": {"Asia/Baghdad", false},
+	"EG": {"Africa/Cairo", false},
+	"ZA": {"Africa/Johannesburg", false},
+	"NG": {"Africa/Lagos", false},
+	"KE": {"Africa/Nairobi", false},
+	"IN": {"Asia/Kolkata", false},
+	"PK": {"Asia/Karachi", false},
+	"BD": {"Asia/Dhaka", false},
+	"JP": {"Asia/Tokyo", false},
+	"KR": {"Asia/Seoul", false},
+	"KP": {"Asia/Pyongyang", false},
+	"MN": {"Asia/Ulaanbaatar", true},
+	"KZ": {"Asia/Almaty", true},
+	"VN": {"Asia/Ho_Chi_Minh", false},
+	"TH": {"Asia/Bangkok", false},
+	"MY": {"Asia/Kuala_Lumpur", false},
+	"SG": {"Asia/Singapore", false},
+	"ID": {"Asia/Jakarta", true},
+	"PH": {"Asia/Manila", false},
+	"KH": {"Asia/Phnom_Penh", false},
+	"MM": {"Asia/Yangon", false},
+	"LA": {"Asia/Vientiane", false},
+	"NP": {"Asia/Kathmandu", false},
+	"LK": {"Asia/Colombo", false},
+	"AU": {"Australia/Sydney", true},
+	"NZ": {"Pacific/Auckland", true},
+}
+
+// timeZoneText returns the current UTC offset of a country, e.g. "UTC+8",
+// prefixed with "~" where the capital's zone stands in for several, or
+// "" if the country or its zone is unknown
+func timeZoneText(country string) string {
+	zone, ok := countryZones[CountryCode(country)]
+	if !ok {
+		return ""
+	}
+	loc, err := time.LoadLocation(zone.name)
+	if err != nil {
+		return ""
+	}
+
+	_, offset := time.Now().In(loc).Zone()
+	text := formatOffset(offset)
+	if zone.approx {
+		text = "~" + text
+	}
+	return text
+}
+
+// formatOffset formats an offset in seconds east of UTC like "UTC+5:30"
+func formatOffset(offset int) string {
+	sign := "+"
+	if offset < 0 {
+		sign, offset = "-", -offset
+	}
+	hours, minutes := offset/3600, offset%3600/60
+	if minutes != 0 {
+		return fmt.Sprintf("UTC%s%d:%02d", sign, hours, minutes)
+	}
+	return fmt.Sprintf("UTC%s%d", sign, hours)
+}
@@ -0,0 +1,68 @@
+package enrich
+
+import (
+	"strings"
+
+	"golang.org/x/text/width"
+)
+
+// DisplayWidth returns the number of terminal columns s occupies,
+// counting East Asian wide and fullwidth characters as two columns
+func DisplayWidth(s string) int {
+	n := 0
+	for _, r := range s {
+		n += runeWidth(r)
+	}
+	return n
+}
+
+// runeWidth returns the number of terminal columns r occupies
+func runeWidth(r rune) int {
+	switch {
+	case r < 0x20 || r == 0x7f:
+		// Control characters take no space
+		return 0
+	case r == 0x200d || (r >= 0xfe00 && r <= 0xfe0f):
+		// Zero width joiner and variation selectors
+		return 0
+	case r >= 0x1f1e6 && r <= 0x1f1ff:
+		// Regional indicators pair up into one two-column flag
+		return 1
+	default:
+		switch width.LookupRune(r).Kind() {
+		case width.EastAsianWide, width.EastAsianFullwidth:
+			return 2
+		default:
+			return 1
+		}
+	}
+}
+
+// padRight pads s with spaces up to the given display width
+func padRight(s string, columns int) string {
+	if pad := columns - DisplayWidth(s); pad > 0 {
+		return s + strings.Repeat(" ", pad)
+	}
+	return s
+}
+
+// ellipsis ends text cut short by truncateWidth
+const ellipsis = "…"
+
+// truncateWidth cuts s to at most columns display columns, ending it with
+// an ellipsis if anything was cut; columns <= 0 leaves s as it is
+func truncateWidth(s string, columns int) string {
+	if columns <= 0 || DisplayWidth(s) <= columns {
+		return s
+	}
+
+	// Leave room for the ellipsis, never splitting a wide character
+	used := 0
+	for i, r := range s {
+		if used+runeWidth(r) > columns-DisplayWidth(ellipsis) {
+			return s[:i] + ellipsis
+		}
+		used += runeWidth(r)
+	}
+	return s
+}
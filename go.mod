@@ -3,7 +3,12 @@ module ip
 go 1.25.4
 
 require (
-	github.com/ipipdotnet/ipdb-go v1.3.3 // indirect
-	github.com/xiaoqidun/qqwry v0.0.0-20250915110312-1dd385f77d98 // indirect
-	golang.org/x/text v0.29.0 // indirect
+	github.com/BurntSushi/toml v1.6.0
+	github.com/creack/pty v1.1.24
+	github.com/ipipdotnet/ipdb-go v1.3.3
+	github.com/oschwald/maxminddb-golang v1.13.1
+	github.com/xiaoqidun/qqwry v0.0.0-20250915110312-1dd385f77d98
+	golang.org/x/text v0.29.0
 )
+
+require golang.org/x/sys v0.21.0 // indirect
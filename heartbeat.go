@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// heartbeat prints a timestamp line whenever the stream has been idle
+// for an interval, so a quiet "tail -f" visibly hasn't hung
+type heartbeat struct {
+	interval time.Duration
+	w        io.Writer
+	dim      bool         // print the line dimmed
+	last     atomic.Int64 // unix nanoseconds of the last line printed
+	timer    *time.Timer
+}
+
+// newHeartbeat starts a heartbeat writing to w after interval of silence
+func newHeartbeat(interval time.Duration, w io.Writer, dim bool) *heartbeat {
+	h := &heartbeat{interval: interval, w: w, dim: dim}
+	h.last.Store(time.Now().UnixNano())
+	h.timer = time.AfterFunc(interval, h.beat)
+	return h
+}
+
+// beat reports how long the stream has been idle and waits another interval
+func (h *heartbeat) beat() {
+	since := time.Unix(0, h.last.Load())
+	text := fmt.Sprintf("-- %s: no output since %s --",
+		time.Now().Format("15:04:05"), since.Format("15:04:05"))
+	if h.dim {
+		text = "\033[2m" + text + "\033[0m"
+	}
+	fmt.Fprintln(h.w, text)
+	h.timer.Reset(h.interval)
+}
+
+// reset restarts the idle interval after a line was printed
+func (h *heartbeat) reset() {
+	if h == nil {
+		return
+	}
+	h.last.Store(time.Now().UnixNano())
+	h.timer.Reset(h.interval)
+}
+
+// stop ends the heartbeat
+func (h *heartbeat) stop() {
+	if h != nil {
+		h.timer.Stop()
+	}
+}
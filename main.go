@@ -1,181 +1,1011 @@
 package main
 
 import (
-	"bufio"
+	"errors"
+	"flag"
 	"fmt"
 	"io"
-	"net/http"
+	"io/fs"
+	"log/slog"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+	_ "time/tzdata" // time zones for --show-tz on systems without zoneinfo
 
-	"github.com/xiaoqidun/qqwry"
+	"ip/enrich"
 )
 
 const (
 	// IPDB file name
 	ipdbFileName = "qqwry.ipdb"
-	// CDN download URL
-	ipdbDownloadURL = "https://cdn.jsdelivr.net/npm/qqwry.raw.ipdb/qqwry.ipdb"
 )
 
-// ensureIPDB checks if IP database exists, downloads if not
-func ensureIPDB() error {
-	// Get executable directory
-	exePath, err := os.Executable()
-	if err != nil {
-		return fmt.Errorf("failed to get executable path: %w", err)
-	}
-	exeDir := filepath.Dir(exePath)
-	ipdbPath := filepath.Join(exeDir, ipdbFileName)
+// options holds the parsed command line options
+type options struct {
+	offline    bool          // skip downloading the database
+	dbPath     string        // database path override
+	color      string        // color mode: auto, always or never
+	json       bool          // emit JSON records instead of annotated text
+	format     string        // annotation template
+	afterPort  bool          // annotate after ":port" instead of before it
+	maxAge     int           // refresh the database after this many days
+	summary    bool          // print a location tally to stderr at exit
+	dedupLine  bool          // annotate each IP only once per line
+	foreign    bool          // annotate foreign IPs only
+	pty        bool          // run the command in a pseudo-terminal
+	rdns       bool          // add reverse DNS names to annotations
+	rdnsWait   time.Duration // reverse DNS timeout
+	backends   listFlag      // database backend, then "name:path" fallback databases
+	workers    int           // number of lines resolved in parallel
+	maxLine    int           // longest line enriched in one piece, in bytes
+	onlyIP     bool          // drop lines without IPs
+	showISP    bool          // append the ISP to the location
+	flag       bool          // prefix locations with a flag emoji
+	skipURLs   bool          // leave URL hosts unannotated
+	asn        bool          // add autonomous system info
+	asnDBPath  string        // MaxMind ASN database path
+	test       bool          // print match diagnostics for stdin
+	config     string        // config file path
+	highlight  bool          // mark matched IPs in bold and underline
+	replace    bool          // replace IPs with their location
+	padWidth   int           // pad annotations to this display width
+	home       string        // country treated as domestic
+	errLabels  bool          // label NotFound and LookupError apart
+	verbose    bool          // include lookup error details
+	firstIP    bool          // annotate only the first IP of each line
+	decodeInt  bool          // match IPv4 written as integers
+	batch      bool          // buffer output instead of flushing every line
+	maxLines   int           // stop after this many lines, 0 for no limit
+	jsonAware  bool          // annotate JSON lines with sibling fields
+	quiet      bool          // silence download progress
+	skipCIDR   listFlag      // networks left unannotated
+	onlyCIDR   listFlag      // networks annotated exclusively
+	explain    string        // IP to show the raw database record of
+	metricsTo  string        // Prometheus textfile path
+	metricsIv  time.Duration // textfile write interval
+	metricsAt  string        // address serving Prometheus metrics
+	sep        string        // separator between adjacent location fields
+	dedupFlds  bool          // drop repeated location fields
+	stderr     bool          // enrich the command's stderr as well
+	tsvOut     string        // TSV sidecar path
+	context    int           // lines of context around foreign hits
+	locale     string        // language of location names
+	tee        string        // file receiving the raw, unenriched output
+	preload    string        // file of IPs to resolve before reading input
+	window     time.Duration // interval of rolling per-country counts
+	placement  string        // annotation before or after the IP
+	mergeAdj   bool          // abbreviate locations repeated from the line above
+	lookupCmd  string        // external command resolving IPs to labels
+	unwrap     int           // terminal width at which input lines were wrapped
+	noSpecial  bool          // look up private and reserved IPs too
+	logLevel   string        // least severe diagnostics logged, "" for none
+	logFormat  string        // log format, text or json
+	groupList  bool          // annotate lists of IPs once, after the list
+	dbInfo     bool          // print database metadata and exit
+	homeCoord  string        // "lat,lon" distances are measured from
+	distance   bool          // add distance and direction from homeCoord
+	csvColumn  int           // CSV column (1-based) holding the IP to annotate
+	csvDelim   string        // CSV field delimiter
+	csvComma   rune          // csvDelim as parsed
+	firstSeen  bool          // annotate each IP only the first time it appears
+	overrides  string        // file of custom labels for IPs and networks
+	keepCRLF   bool          // end lines read with "\r\n" the same way
+	maxIPs     int           // most IPs annotated per line
+	echoCmd    bool          // print the command as a header line
+	bestEffort bool          // pass output through if the database can't be loaded
+	heartbeat  time.Duration // idle time after which to show a liveness line
+	showTZ     bool          // add the UTC offset of the country
+	serve      string        // address to serve POST /enrich on
+	skipTimes  bool          // leave timestamp-like dotted quads unannotated
+	strip      bool          // remove earlier ip-plus annotations from the input
+	reEnrich   bool          // strip, then annotate afresh
+	zeroPad    bool          // match IPv4 with zero-padded octets
+	maxLocW    int           // cut locations to this display width
+	annCol     int           // append annotations at this column
+	lookupWait time.Duration // longest wait for one IP's lookup
+	inFile     string        // file to enrich instead of stdin
+	outFile    string        // file to write instead of stdout
+	inPlace    bool          // replace inFile with its enriched version
+	annotators listFlag      // annotators composing each annotation, in order
+	dlTimeout  time.Duration // time limit for each download attempt
+	dlRetries  int           // download retries per URL
+	dbSHA256   string        // expected SHA-256 of downloaded databases
+}
+
+// listFlag collects the values of a repeatable flag, also
+// accepting comma separated lists
+type listFlag []string
+
+func (l *listFlag) String() string {
+	return strings.Join(*l, ",")
+}
 
-	// Check if file exists
-	if _, err := os.Stat(ipdbPath); err == nil {
-		return nil // File already exists
+func (l *listFlag) Set(value string) error {
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			*l = append(*l, item)
+		}
 	}
+	return nil
+}
 
-	// Download the database
-	fmt.Fprintf(os.Stderr, "Downloading IP database...\n")
+// envBool reports whether an environment variable is set to a true value
+func envBool(name string) bool {
+	value, err := strconv.ParseBool(os.Getenv(name))
+	return err == nil && value
+}
 
-	resp, err := http.Get(ipdbDownloadURL)
+// envInt returns the integer value of an environment variable, or def if unset or invalid
+func envInt(name string, def int) int {
+	value, err := strconv.Atoi(os.Getenv(name))
 	if err != nil {
-		return fmt.Errorf("failed to download IP database: %w", err)
+		return def
 	}
-	defer resp.Body.Close()
+	return value
+}
 
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("failed to download IP database: HTTP %d", resp.StatusCode)
-	}
+// parseFlags parses command line options and the config file,
+// and returns them with the remaining arguments
+func parseFlags() (*options, []string, error) {
+	opts := &options{}
 
-	// Create temporary file
-	tmpFile, err := os.CreateTemp(exeDir, "qqwry-*.ipdb.tmp")
-	if err != nil {
-		return fmt.Errorf("failed to create temp file: %w", err)
-	}
-	tmpPath := tmpFile.Name()
-	defer os.Remove(tmpPath) // Clean up on failure
-
-	// Download with progress
-	totalSize := resp.ContentLength
-	downloaded := int64(0)
-	buffer := make([]byte, 32*1024) // 32KB buffer
-
-	for {
-		n, err := resp.Body.Read(buffer)
-		if n > 0 {
-			if _, writeErr := tmpFile.Write(buffer[:n]); writeErr != nil {
-				tmpFile.Close()
-				return fmt.Errorf("failed to write to temp file: %w", writeErr)
-			}
-			downloaded += int64(n)
+	flag.BoolVar(&opts.offline, "no-download", envBool("IP_PLUS_OFFLINE"),
+		"never download the IP database (env IP_PLUS_OFFLINE)")
+	flag.BoolVar(&opts.offline, "offline", envBool("IP_PLUS_OFFLINE"),
+		"alias for --no-download")
+	flag.StringVar(&opts.dbPath, "db", os.Getenv("IP_PLUS_DB"),
+		"path to the IP database (env IP_PLUS_DB, default: next to the executable)")
+	flag.Var(&opts.backends, "backend",
+		"database backend: qqwry (.ipdb or legacy .dat), maxmind or ip2region\n"+
+			"(default: by file extension, .mmdb or .xdb); repeat as name:path to add fallback\n"+
+			"databases, consulted in order when those before find no country")
+	flag.IntVar(&opts.maxAge, "max-age", envInt("IP_PLUS_MAX_AGE", 30),
+		"re-download the database when older than this many days, 0 to disable (env IP_PLUS_MAX_AGE)")
+	flag.DurationVar(&opts.dlTimeout, "download-timeout", 60*time.Second,
+		"time limit for each database download attempt")
+	flag.IntVar(&opts.dlRetries, "download-retries", 2,
+		"retries per URL when a database download fails transiently")
+	flag.StringVar(&opts.dbSHA256, "db-sha256", os.Getenv("IP_PLUS_DB_SHA256"),
+		"expected SHA-256 of the downloaded database; otherwise a .sha256 file next\n"+
+			"to it is used, or failing that only its format is checked (env IP_PLUS_DB_SHA256)")
+	flag.StringVar(&opts.color, "color", "auto",
+		"colorize annotations: auto, always or never")
+	flag.BoolVar(&opts.json, "json", false,
+		"emit one JSON object per line instead of inline annotations")
+	flag.StringVar(&opts.format, "format", enrich.DefaultFormat,
+		"annotation template; placeholders: {country} {province} {city} {district} {isp}\n"+
+			"text between placeholders is dropped when a field is empty")
+	flag.BoolVar(&opts.afterPort, "after-port", false,
+		"place annotations after a trailing :port, e.g. \"1.2.3.4:22 (Local)\"")
+	flag.BoolVar(&opts.summary, "summary", false,
+		"print a per-location count of all IPs seen to stderr at exit")
+	flag.BoolVar(&opts.dedupLine, "dedup-line", false,
+		"annotate only the first occurrence of an IP within a line")
+	flag.BoolVar(&opts.foreign, "foreign-only", false,
+		"annotate only foreign IPs, skipping domestic and local ones")
+	flag.BoolVar(&opts.pty, "pty", false,
+		"run the command in a pseudo-terminal so it keeps colored output")
+	flag.BoolVar(&opts.rdns, "rdns", false,
+		"add the reverse DNS (PTR) name of each IP to its annotation")
+	flag.DurationVar(&opts.rdnsWait, "rdns-timeout", 500*time.Millisecond,
+		"maximum time to wait for each reverse DNS lookup")
+	flag.IntVar(&opts.workers, "workers", runtime.NumCPU(),
+		"number of lines to resolve in parallel, output order is preserved")
+	flag.IntVar(&opts.maxLine, "max-line-size", 1<<20,
+		"longest line in bytes enriched in one piece; longer lines are split, not dropped")
+	flag.BoolVar(&opts.onlyIP, "only-ip", false,
+		"only print lines that contain at least one IP address")
+	flag.BoolVar(&opts.showISP, "show-isp", false,
+		"append the ISP/operator to the location if --format lacks {isp}")
+	flag.BoolVar(&opts.flag, "flag", false,
+		"prefix locations with the country's flag emoji")
+	flag.BoolVar(&opts.skipURLs, "skip-urls", false,
+		"don't annotate IPs that are the host of a URL (after :// or before a /path)")
+	flag.BoolVar(&opts.asn, "asn", false,
+		"add the autonomous system number and organization to annotations (needs --asn-db)")
+	flag.StringVar(&opts.asnDBPath, "asn-db", os.Getenv("IP_PLUS_ASN_DB"),
+		"path to a MaxMind GeoLite2-ASN database (env IP_PLUS_ASN_DB)")
+	flag.BoolVar(&opts.test, "test", false,
+		"read lines from stdin and show the IPs found, their positions and locations")
+	flag.BoolVar(&opts.highlight, "highlight", false,
+		"mark matched IPs in bold and underline when color is enabled")
+	flag.BoolVar(&opts.replace, "replace", false,
+		"replace IPs with their location instead of annotating them")
+	flag.IntVar(&opts.padWidth, "pad", 0,
+		"pad annotations to this many terminal columns (CJK counts as two) to keep columns aligned")
+	flag.StringVar(&opts.home, "home-country", "中国",
+		"country treated as domestic by --foreign-only and colors, as named by the database or an ISO code")
+	flag.BoolVar(&opts.errLabels, "error-labels", false,
+		"label IPs missing from the database NotFound and failed lookups LookupError instead of Unknown")
+	flag.BoolVar(&opts.verbose, "verbose", false,
+		"like --error-labels, and include the error text in LookupError labels")
+	flag.BoolVar(&opts.firstIP, "first-ip-only", false,
+		"annotate only the first (leftmost) IP of each line")
+	flag.BoolVar(&opts.decodeInt, "decode-int-ips", false,
+		"also match IPv4 written as a 32-bit decimal or 0x hex integer, e.g. 3232235521")
+	flag.BoolVar(&opts.batch, "batch", false,
+		"buffer output for throughput instead of flushing after every line")
+	flag.IntVar(&opts.maxLines, "max-lines", 0,
+		"stop after reading this many lines, terminating the command; lines filters\n"+
+			"drop count too (0 for no limit)")
+	flag.BoolVar(&opts.jsonAware, "json-aware", false,
+		"keep JSON lines valid by adding a \"<key>_geo\" field after string values with IPs")
+	flag.BoolVar(&opts.quiet, "quiet", envBool("IP_PLUS_QUIET"),
+		"don't report database download progress, only errors (env IP_PLUS_QUIET)")
+	flag.Var(&opts.skipCIDR, "skip-cidr",
+		"leave IPs in this CIDR block or equal to this IP unannotated (repeatable)")
+	flag.Var(&opts.onlyCIDR, "only-cidr",
+		"only annotate IPs in this CIDR block or equal to this IP (repeatable)")
+	flag.StringVar(&opts.explain, "explain", "",
+		"show the raw database record, verdicts and annotation of one IP and exit")
+	flag.StringVar(&opts.metricsTo, "metrics-file", "",
+		"write Prometheus metrics (lines, IPs, countries, cache hits) to this textfile")
+	flag.DurationVar(&opts.metricsIv, "metrics-interval", 15*time.Second,
+		"how often to rewrite --metrics-file")
+	flag.StringVar(&opts.metricsAt, "metrics-addr", "",
+		"serve Prometheus metrics on http://<addr>/metrics, e.g. :9100")
+	flag.StringVar(&opts.sep, "sep", "",
+		"separator between location fields that --format puts no text between, e.g. \"/\"")
+	flag.BoolVar(&opts.dedupFlds, "dedup-fields", false,
+		"show a location field only once if it repeats the one before, e.g. a province and city of 北京")
+	flag.BoolVar(&opts.stderr, "stderr", false,
+		"also enrich the command's stderr, writing it back to stderr (implied by --pty)")
+	flag.StringVar(&opts.tsvOut, "tsv-out", "",
+		"also write a row per matched IP (line, ip, country, province, city) to this TSV file")
+	flag.IntVar(&opts.context, "context", 0,
+		"only print lines with foreign IPs and this many lines before and after each, like grep -C")
+	flag.StringVar(&opts.locale, "locale", "",
+		"translate country and province names: en for English (default: as in the database)")
+	flag.StringVar(&opts.tee, "tee", "",
+		"also save the raw, unenriched output verbatim to this file")
+	flag.StringVar(&opts.preload, "preload", "",
+		"resolve the IPs in this file, one per line, into the cache before reading input")
+	flag.DurationVar(&opts.window, "window", 0,
+		"print per-country counts to stderr every interval, e.g. 10s, then start over")
+	flag.StringVar(&opts.placement, "placement", enrich.PlacementAfter,
+		"put annotations before or after the IP, e.g. \"(美国) 8.8.8.8\" with before")
+	flag.BoolVar(&opts.mergeAdj, "merge-adjacent", false,
+		"abbreviate the location to 〃 on lines whose IPs all resolve like the line above")
+	flag.StringVar(&opts.lookupCmd, "lookup-cmd", "",
+		"resolve IPs by running this command with the IP as last argument, using the first\n"+
+			"line it prints as the location; the database is used when it fails")
+	flag.IntVar(&opts.unwrap, "unwrap", 0,
+		"rejoin lines hard-wrapped at this many columns before matching IPs, so an IP\n"+
+			"split across them is found; output keeps the original line breaks")
+	flag.BoolVar(&opts.noSpecial, "no-special", false,
+		"look up private, loopback and reserved IPs in the database instead of labeling them Local")
+	flag.StringVar(&opts.logLevel, "log-level", "",
+		"log the tool's own operations to stderr at this level: debug, info, warn or error")
+	flag.StringVar(&opts.logFormat, "log-format", "text",
+		"format of --log-level output: text or json")
+	flag.BoolVar(&opts.groupList, "group-lists", false,
+		"annotate comma or space separated lists of IPs once, e.g. \"1.1.1.1, 8.8.8.8 [APNIC, GOOGLE.COM]\"")
+	flag.BoolVar(&opts.dbInfo, "db-info", false,
+		"print the path, size, age and build metadata of the IP database and exit")
+	flag.StringVar(&opts.homeCoord, "home-coords", "",
+		"your position as lat,lon in decimal degrees, for --show-distance, e.g. 31.23,121.47")
+	flag.BoolVar(&opts.distance, "show-distance", false,
+		"add the distance and direction from --home-coords, e.g. \"(北京, 1068km NW)\"; qqwry\n"+
+			"databases only place Chinese provinces, MaxMind City databases place any IP")
+	flag.IntVar(&opts.csvColumn, "csv-column", 0,
+		"parse lines as CSV and append the location of the IP in this column (1-based)\n"+
+			"as a new last column instead of annotating inline")
+	flag.StringVar(&opts.csvDelim, "csv-delimiter", ",",
+		"field delimiter for --csv-column, one character; \\t for tab")
+	flag.BoolVar(&opts.firstSeen, "first-seen-only", false,
+		"annotate each IP only the first time it appears in the run, leaving repeats bare")
+	flag.StringVar(&opts.overrides, "overrides", "",
+		"file of ip=label or cidr=label lines labeling these IPs instead of the database,\n"+
+			"the most specific network winning, e.g. 203.0.113.7=prod-db-1")
+	flag.BoolVar(&opts.keepCRLF, "keep-crlf", false,
+		"end lines that ended in \\r\\n (e.g. from Windows tools) with \\r\\n again instead of \\n")
+	flag.IntVar(&opts.maxIPs, "max-ips-per-line", 64,
+		"annotate at most this many IPs of each line, leaving the rest bare (0 for no limit)")
+	flag.BoolVar(&opts.echoCmd, "echo-cmd", false,
+		"print the command as a \"$ cmd args\" header line, its IPs annotated, before its output\n"+
+			"(not with --json)")
+	flag.BoolVar(&opts.bestEffort, "best-effort", false,
+		"if the database can't be downloaded or loaded, warn and pass output through unannotated")
+	flag.DurationVar(&opts.heartbeat, "heartbeat", 0,
+		"print a timestamp line to stderr whenever no line has been read for this long, e.g. 30s")
+	flag.BoolVar(&opts.showTZ, "show-tz", false,
+		"add the country's current UTC offset, e.g. \"(北京, UTC+8)\"; ~ marks the capital's\n"+
+			"offset standing in for a country with several zones")
+	flag.StringVar(&opts.serve, "serve", "",
+		"serve POST /enrich on this address, e.g. :8080, answering with the enriched body\n"+
+			"(?format=json for JSON lines) instead of running a command")
+	flag.BoolVar(&opts.skipTimes, "skip-timestamp-like", false,
+		"leave dotted quads that look like timestamps unannotated, e.g. \"10.24.15.30\"\n"+
+			"next to a date or time, or inside \"2024.10.24.15.30\"")
+	flag.BoolVar(&opts.strip, "strip", false,
+		"remove annotations an earlier ip-plus run added in the --format in use and print\n"+
+			"the text without annotating it again")
+	flag.BoolVar(&opts.reEnrich, "re-enrich", false,
+		"remove earlier annotations like --strip, then annotate afresh")
+	flag.BoolVar(&opts.zeroPad, "allow-leading-zeros", false,
+		"also match IPv4 with zero-padded octets, e.g. 192.168.001.001, looked up as\n"+
+			"192.168.1.1 (octets are decimal) while the line keeps the original form")
+	flag.IntVar(&opts.maxLocW, "max-loc-width", 0,
+		"cut locations wider than this many terminal columns short with \"…\", counting\n"+
+			"CJK characters as two (0 for no limit)")
+	flag.IntVar(&opts.annCol, "annotation-column", 0,
+		"leave IPs in place and append each line's annotations padded to start at this\n"+
+			"display column, so they line up in tables (0 to annotate next to each IP)")
+	flag.DurationVar(&opts.lookupWait, "lookup-timeout", 0,
+		"longest wait for one IP's lookup, e.g. 200ms with --rdns or --lookup-cmd; slower IPs\n"+
+			"are labeled Timeout and resolved in the background for later lines (0 waits)")
+	flag.StringVar(&opts.inFile, "input-file", "",
+		"enrich this file instead of stdin, reading it line by line")
+	flag.StringVar(&opts.outFile, "output-file", "",
+		"write the enriched lines to this file instead of stdout")
+	flag.BoolVar(&opts.inPlace, "in-place", false,
+		"replace --input-file with its enriched version, written to a temp file first")
+	flag.Var(&opts.annotators, "annotators",
+		"annotators whose texts make up each annotation, in order and joined by \" / \"\n"+
+			"(default: geo, the location; registered: "+strings.Join(enrich.AnnotatorNames(), ", ")+")")
+	flag.StringVar(&opts.config, "config", os.Getenv("IP_PLUS_CONFIG"),
+		"TOML config file with defaults keyed by option name (env IP_PLUS_CONFIG,\n"+
+			"default: $XDG_CONFIG_HOME/ip-plus/config.toml)")
 
-			if totalSize > 0 {
-				fmt.Fprintf(os.Stderr, "\rDownloading: %.2f MB / %.2f MB (%.1f%%)",
-					float64(downloaded)/(1024*1024),
-					float64(totalSize)/(1024*1024),
-					float64(downloaded)*100/float64(totalSize))
-			}
-		}
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			tmpFile.Close()
-			return fmt.Errorf("failed to download: %w", err)
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [options] <command> [args...]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s [options] < input\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s [options] download [--force]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Example: %s ss -nltp\n\nOptions:\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	// Fill in options not given on the command line from the config file
+	configPath, required := opts.config, true
+	if configPath == "" {
+		configPath, required = defaultConfigPath(), false
+	}
+	if configPath != "" {
+		if err := loadConfig(flag.CommandLine, configPath, required); err != nil {
+			return nil, nil, err
 		}
 	}
 
-	tmpFile.Close()
-	fmt.Fprintf(os.Stderr, "\nDownload complete!\n")
+	return opts, flag.Args(), nil
+}
 
-	// Rename temp file to final name
-	if err := os.Rename(tmpPath, ipdbPath); err != nil {
-		return fmt.Errorf("failed to move database file: %w", err)
+// parseDelimiter parses a one-character field delimiter, accepting
+// "\t" for a tab
+func parseDelimiter(text string) (rune, error) {
+	if text == `\t` {
+		return '\t', nil
+	}
+	runes := []rune(text)
+	if len(runes) != 1 || runes[0] == '"' || runes[0] == '\n' || runes[0] == '\r' {
+		return 0, fmt.Errorf("invalid delimiter: %q (expected one character)", text)
 	}
+	return runes[0], nil
+}
 
-	return nil
+// isTerminal reports whether the file is a terminal
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// useColor resolves the color mode into whether colors should be emitted
+func useColor(mode string) (bool, error) {
+	switch mode {
+	case "auto":
+		return isTerminal(os.Stdout), nil
+	case "always":
+		return true, nil
+	case "never":
+		return false, nil
+	default:
+		return false, fmt.Errorf("invalid color mode: %s (expected auto, always or never)", mode)
+	}
 }
 
-// loadIPDB loads the IP database
-func loadIPDB() error {
-	// Get executable directory
+// getIPDBPath returns the database path override if set,
+// otherwise the path of the IP database next to the executable
+func getIPDBPath(override string) (string, error) {
+	if override != "" {
+		return override, nil
+	}
+
 	exePath, err := os.Executable()
 	if err != nil {
-		return fmt.Errorf("failed to get executable path: %w", err)
+		return "", fmt.Errorf("failed to get executable path: %w", err)
+	}
+	return filepath.Join(filepath.Dir(exePath), ipdbFileName), nil
+}
+
+// splitBackends splits the values of --backend into the backend of
+// --db, given by name alone, and fallback databases given as name:path
+func splitBackends(values []string) (string, []string, error) {
+	backend, fallbacks := "", []string{}
+	for _, value := range values {
+		name, path, hasPath := strings.Cut(value, ":")
+		switch name {
+		case enrich.BackendQQWry, enrich.BackendMaxMind, enrich.BackendIP2Region:
+		default:
+			return "", nil, fmt.Errorf("unknown backend: %s", name)
+		}
+		switch {
+		case hasPath && path == "":
+			return "", nil, fmt.Errorf("missing database path: %s", value)
+		case hasPath:
+			fallbacks = append(fallbacks, value)
+		case backend != "" && backend != name:
+			return "", nil, fmt.Errorf("conflicting backends for --db: %s and %s", backend, name)
+		default:
+			backend = name
+		}
+	}
+	return backend, fallbacks, nil
+}
+
+// loadIPDB loads the IP database with the named backend
+func loadIPDB(ipdbPath, backend string) (enrich.Backend, error) {
+	// Report a missing file clearly, since it may not have been downloaded
+	if _, err := os.Stat(ipdbPath); os.IsNotExist(err) {
+		return nil, fmt.Errorf("IP database not found: %s", ipdbPath)
 	}
-	exeDir := filepath.Dir(exePath)
-	ipdbPath := filepath.Join(exeDir, ipdbFileName)
 
 	// Load the database
-	if err := qqwry.LoadFile(ipdbPath); err != nil {
-		return fmt.Errorf("failed to load IP database: %w", err)
+	return enrich.Open(ipdbPath, backend)
+}
+
+// shellJoin joins args into a command line, single-quoting those a
+// shell would split or expand
+func shellJoin(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		if arg != "" && !strings.ContainsAny(arg, " \t\n'\"\\$`|&;<>()*?[]{}~#!") {
+			quoted[i] = arg
+			continue
+		}
+		quoted[i] = "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
 	}
+	return strings.Join(quoted, " ")
+}
 
-	return nil
+// exitStartError reports that the command couldn't be started and exits
+// like a shell would: 127 if it wasn't found, 126 if it can't be run
+func exitStartError(name string, err error) {
+	switch {
+	case errors.Is(err, exec.ErrNotFound), errors.Is(err, fs.ErrNotExist):
+		fmt.Fprintf(os.Stderr, "Error: command not found: %s\n", name)
+		os.Exit(127)
+	case errors.Is(err, fs.ErrPermission):
+		fmt.Fprintf(os.Stderr, "Error: permission denied: %s\n", name)
+		os.Exit(126)
+	default:
+		fmt.Fprintf(os.Stderr, "Error starting command: %v\n", err)
+		os.Exit(1)
+	}
 }
 
 func main() {
-	// Check if command is provided
-	if len(os.Args) < 2 {
-		fmt.Fprintf(os.Stderr, "Usage: %s <command> [args...]\n", os.Args[0])
-		fmt.Fprintf(os.Stderr, "Example: %s ss -nltp\n", os.Args[0])
+	opts, args, err := parseFlags()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 		os.Exit(1)
 	}
 
-	// Ensure IP database exists
-	if err := ensureIPDB(); err != nil {
+	// Keep diagnostics off unless asked for
+	logger, err := newLogger(opts.logLevel, opts.logFormat, os.Stderr)
+	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-		fmt.Fprintf(os.Stderr, "Please manually download database file to: %s\n", ipdbFileName)
-		fmt.Fprintf(os.Stderr, "Download URL: %s\n", ipdbDownloadURL)
 		os.Exit(1)
 	}
+	slog.SetDefault(logger)
+
+	// Check the input and output options fit together
+	switch {
+	case opts.inFile != "" && len(args) > 0:
+		fmt.Fprintf(os.Stderr, "Error: --input-file replaces the command, give one or the other\n")
+		os.Exit(1)
+	case opts.inPlace && opts.inFile == "":
+		fmt.Fprintf(os.Stderr, "Error: --in-place requires --input-file\n")
+		os.Exit(1)
+	case opts.inPlace && opts.outFile != "":
+		fmt.Fprintf(os.Stderr, "Error: --in-place and --output-file can't be combined\n")
+		os.Exit(1)
+	case opts.inPlace && opts.maxLines > 0:
+		// Replacing the file with its first lines would lose the rest
+		fmt.Fprintf(os.Stderr, "Error: --in-place and --max-lines can't be combined\n")
+		os.Exit(1)
+	}
+
+	// Check if command is provided, or input is piped in
+	if len(args) < 1 && !opts.test && opts.explain == "" && !opts.dbInfo && opts.serve == "" &&
+		opts.inFile == "" && isTerminal(os.Stdin) {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	// Configure enrichment
+	color, err := useColor(opts.color)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	if opts.color == "auto" && (opts.outFile != "" || opts.inPlace) {
+		// Files get no escape codes, whatever stdout is
+		color = false
+	}
+	enrichOpts := enrich.Options{
+		Color:            color,
+		Format:           opts.format,
+		AfterPort:        opts.afterPort,
+		Placement:        opts.placement,
+		LookupCommand:    strings.Fields(opts.lookupCmd),
+		NoSpecial:        opts.noSpecial,
+		Logger:           logger,
+		GroupLists:       opts.groupList,
+		FirstSeenOnly:    opts.firstSeen,
+		MaxIPsPerLine:    opts.maxIPs,
+		ShowTimeZone:     opts.showTZ,
+		DedupLine:        opts.dedupLine,
+		ForeignOnly:      opts.foreign,
+		RDNS:             opts.rdns,
+		RDNSTimeout:      opts.rdnsWait,
+		LookupTimeout:    opts.lookupWait,
+		Annotators:       opts.annotators,
+		ShowISP:          opts.showISP,
+		Flag:             opts.flag,
+		SkipURLs:         opts.skipURLs,
+		SkipTimestamps:   opts.skipTimes,
+		Highlight:        opts.highlight,
+		Replace:          opts.replace,
+		PadWidth:         opts.padWidth,
+		MaxLocWidth:      opts.maxLocW,
+		AnnotationColumn: opts.annCol,
+		HomeCountry:      opts.home,
+		ErrorLabels:      opts.errLabels,
+		Verbose:          opts.verbose,
+		FirstIPOnly:      opts.firstIP,
+		DecodeIntIPs:     opts.decodeInt,
+		LeadingZeros:     opts.zeroPad,
+		Separator:        opts.sep,
+		DedupFields:      opts.dedupFlds,
+		Warnings:         os.Stderr,
+		Locale:           opts.locale,
+	}
+	template, err := enrich.ParseTemplate(opts.format)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	if opts.strip || opts.reEnrich {
+		if !template.Wrapped() {
+			fmt.Fprintf(os.Stderr, "Error: --strip needs a --format with text on both sides of the location, like the default\n")
+			os.Exit(1)
+		}
+		// Annotations are only taken out with --strip
+		enrichOpts.Passthrough = !opts.reEnrich
+		opts.strip = true
+	}
+	if opts.overrides != "" {
+		if enrichOpts.Overrides, err = loadOverrides(opts.overrides); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if opts.csvComma, err = parseDelimiter(opts.csvDelim); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: --csv-delimiter: %v\n", err)
+		os.Exit(1)
+	}
+	if opts.distance {
+		if opts.homeCoord == "" {
+			fmt.Fprintf(os.Stderr, "Error: --show-distance requires --home-coords\n")
+			os.Exit(1)
+		}
+		home, err := enrich.ParseCoordinates(opts.homeCoord)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: --home-coords: %v\n", err)
+			os.Exit(1)
+		}
+		enrichOpts.DistanceFrom = &home
+	}
+	if enrichOpts.SkipNets, err = enrich.ParseNetworks(opts.skipCIDR); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: --skip-cidr: %v\n", err)
+		os.Exit(1)
+	}
+	if enrichOpts.OnlyNets, err = enrich.ParseNetworks(opts.onlyCIDR); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: --only-cidr: %v\n", err)
+		os.Exit(1)
+	}
+
+	// Resolve database path
+	ipdbPath, err := getIPDBPath(opts.dbPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	// Only qqwry .ipdb databases can be downloaded automatically; a legacy
+	// qqwry.dat is left to whatever tooling maintains it
+	backend, fallbacks, err := splitBackends(opts.backends)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: --backend: %v\n", err)
+		os.Exit(1)
+	}
+	if backend == "" {
+		backend = enrich.DetectBackend(ipdbPath)
+	}
+
+	if opts.dbSHA256 != "" && !validSHA256(strings.ToLower(opts.dbSHA256)) {
+		fmt.Fprintf(os.Stderr, "Error: --db-sha256: %q is not a hex SHA-256 digest\n", opts.dbSHA256)
+		os.Exit(1)
+	}
+
+	// Only download the database, e.g. while provisioning
+	if len(args) > 0 && args[0] == "download" {
+		if opts.offline || backend != enrich.BackendQQWry || enrich.DatabaseExt(ipdbPath) == ".dat" {
+			fmt.Fprintf(os.Stderr, "Error: only qqwry .ipdb databases can be downloaded, and not with --offline\n")
+			os.Exit(1)
+		}
+		dl := &downloader{
+			timeout:  opts.dlTimeout,
+			retries:  opts.dlRetries,
+			quiet:    opts.quiet,
+			progress: isTerminal(os.Stderr),
+			checksum: strings.ToLower(opts.dbSHA256),
+		}
+		if err := dl.runDownload(args[1:], ipdbPath, time.Duration(opts.maxAge)*24*time.Hour); err != nil {
+			if !errors.Is(err, flag.ErrHelp) {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			}
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	// One-shot modes need the database, enriching can do without
+	bestEffort := opts.bestEffort && !opts.dbInfo && opts.explain == ""
+
+	// Ensure IP database exists, unless running offline
+	isDat := enrich.DatabaseExt(ipdbPath) == ".dat"
+	if !opts.offline && backend == enrich.BackendQQWry && !isDat {
+		dl := &downloader{
+			timeout:  opts.dlTimeout,
+			retries:  opts.dlRetries,
+			quiet:    opts.quiet,
+			progress: isTerminal(os.Stderr),
+			checksum: strings.ToLower(opts.dbSHA256),
+		}
+		if err := dl.ensureIPDB(ipdbPath, time.Duration(opts.maxAge)*24*time.Hour); err != nil && bestEffort {
+			// Loading fails below unless an older file is in place
+			fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+		} else if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			fmt.Fprintf(os.Stderr, "Please manually download database file to: %s\n", ipdbPath)
+			fmt.Fprintf(os.Stderr, "Download URL: %s\n", ipdbDownloadURLs[0])
+			os.Exit(1)
+		}
+	}
 
 	// Load IP database
-	if err := loadIPDB(); err != nil {
+	db, err := loadIPDB(ipdbPath, backend)
+	switch {
+	case err != nil && bestEffort:
+		fmt.Fprintf(os.Stderr, "Warning: %v; passing output through without annotations\n", err)
+		db, enrichOpts.Passthrough = nil, true
+	case err != nil:
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 		os.Exit(1)
+	default:
+		slog.Info("database loaded", "path", ipdbPath, "backend", backend)
 	}
 
-	// Prepare command
-	cmdName := os.Args[1]
-	cmdArgs := []string{}
-	if len(os.Args) > 2 {
-		cmdArgs = os.Args[2:]
+	// Describe the database instead of enriching
+	if opts.dbInfo {
+		if err := runDBInfo(os.Stdout, ipdbPath, backend, db); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
 	}
 
-	cmd := exec.Command(cmdName, cmdArgs...)
+	// Fall back to further databases for what this one can't place
+	if len(fallbacks) > 0 && db != nil {
+		chain := enrich.Chain{db}
+		for _, spec := range fallbacks {
+			name, path, _ := strings.Cut(spec, ":")
+			if name == enrich.BackendQQWry && backend == enrich.BackendQQWry {
+				// The qqwry library holds one database at a time
+				fmt.Fprintf(os.Stderr, "Error: --backend: only one qqwry database can be loaded: %s\n", spec)
+				os.Exit(1)
+			}
+			fallback, err := loadIPDB(path, name)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+			slog.Info("database loaded", "path", path, "backend", name)
+			chain = append(chain, fallback)
+		}
+		db = chain
+	}
+
+	// Load ASN database
+	if opts.asn {
+		if opts.asnDBPath == "" {
+			fmt.Fprintf(os.Stderr, "Error: --asn requires --asn-db\n")
+			os.Exit(1)
+		}
+		asnDB, err := enrich.OpenMaxMindASN(opts.asnDBPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		enrichOpts.ASN = asnDB
+	}
 
-	// Get stdout pipe
-	stdout, err := cmd.StdoutPipe()
+	enricher, err := enrich.New(db, enrichOpts)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error creating stdout pipe: %v\n", err)
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 		os.Exit(1)
 	}
 
-	// Pass through stderr directly
-	cmd.Stderr = os.Stderr
+	// Explain a single lookup instead of enriching
+	if opts.explain != "" {
+		if err := runExplain(os.Stdout, db, enricher, opts.explain); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
 
-	// Start the command
-	if err := cmd.Start(); err != nil {
-		fmt.Fprintf(os.Stderr, "Error starting command: %v\n", err)
+	// Diagnose matching instead of enriching
+	if opts.test {
+		if err := runTest(os.Stdin, os.Stdout, enricher, opts.maxLine); err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading input: %v\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	// Warm the cache with known IPs
+	if opts.preload != "" {
+		if err := preloadIPs(enricher, opts.preload, os.Stderr); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	// Enrich for HTTP clients instead of a command or stdin
+	if opts.serve != "" {
+		if err := runServe(opts.serve, enricher, opts); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	// Stop cleanly when the reader of our output goes away
+	catchSIGPIPE()
+
+	// Collect location statistics if requested
+	printer := newStreamPrinter(enricher, opts, os.Stdout)
+	if opts.summary {
+		printer.stats = newSummary()
+	}
+	if opts.window > 0 {
+		printer.window = newWindowCounts(opts.window, os.Stderr)
+	}
+	if opts.heartbeat > 0 {
+		printer.alive = newHeartbeat(opts.heartbeat, os.Stderr, isTerminal(os.Stderr))
+	}
+	if opts.context > 0 {
+		printer.context = newContextWindow(opts.context)
+	}
+	if opts.tsvOut != "" {
+		if printer.tsv, err = openTSV(opts.tsvOut); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if opts.metricsTo != "" || opts.metricsAt != "" {
+		printer.metrics = newMetrics(enricher)
+	}
+	if opts.metricsTo != "" {
+		if err := printer.metrics.startFile(opts.metricsTo, opts.metricsIv); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if opts.metricsAt != "" {
+		if err := printer.metrics.serve(opts.metricsAt); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	// Save the raw input as it's read, before enrichment
+	tee := func(r io.Reader) io.Reader { return r }
+	if opts.tee != "" {
+		rawFile, err := os.Create(opts.tee)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: failed to create tee file: %v\n", err)
+			os.Exit(1)
+		}
+		defer rawFile.Close()
+		tee = func(r io.Reader) io.Reader { return io.TeeReader(r, rawFile) }
+	}
+
+	// Write to a file instead of stdout if asked to; nothing was
+	// printed yet, so the buffer can switch over
+	output, err := createOutput(opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 		os.Exit(1)
 	}
+	if output != nil {
+		printer.out.Reset(output.file)
+	}
 
-	// Process output line by line
-	scanner := bufio.NewScanner(stdout)
-	for scanner.Scan() {
-		line := scanner.Text()
-		enrichedLine := EnrichLine(line)
-		fmt.Println(enrichedLine)
+	// Print the summary and the like before exiting on a signal that
+	// doesn't end the input in time
+	shutdown := func(code int) {
+		printer.finish()
+		output.abort()
+		os.Exit(code)
 	}
 
-	// Check for scanner errors
-	if err := scanner.Err(); err != nil {
+	// Without a command, enrich piped stdin or --input-file
+	if len(args) == 0 {
+		input := io.Reader(os.Stdin)
+		if opts.inFile != "" {
+			file, err := os.Open(opts.inFile)
+			if err != nil {
+				output.abort()
+				fmt.Fprintf(os.Stderr, "Error: failed to open input file: %v\n", err)
+				os.Exit(1)
+			}
+			defer file.Close()
+			input = file
+		}
+
+		signals := forwardSignals(nil, shutdown)
+		err := enrichStream(tee(input), printer)
+		printer.finish()
+		signals.stop()
+		if code := signals.exitCode(); code >= 0 {
+			output.abort()
+			os.Exit(code)
+		}
+		if err == errOutputClosed {
+			output.abort()
+			os.Exit(0)
+		}
+		if err != nil && err != errMaxLines {
+			output.abort()
+			fmt.Fprintf(os.Stderr, "Error reading input: %v\n", err)
+			os.Exit(1)
+		}
+		if err := output.commit(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	// Prepare command
+	cmdName := args[0]
+	cmdArgs := args[1:]
+
+	// Document the command in the output, its IPs annotated too; a JSON
+	// stream is left to records of the command's lines
+	if opts.echoCmd && !opts.json {
+		if err := printer.header(enricher.Enrich("$ " + shellJoin(args))); err == errOutputClosed {
+			output.abort()
+			os.Exit(0)
+		} else if err != nil {
+			output.abort()
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	cmd := exec.Command(cmdName, cmdArgs...)
+
+	var stdout io.ReadCloser
+	var stderrDone chan struct{} // closed once enriched stderr is drained
+	if opts.pty {
+		// Start the command in a pseudo-terminal
+		stdout, err = startPTY(cmd)
+		if err != nil {
+			exitStartError(cmdName, err)
+		}
+		defer stdout.Close()
+	} else {
+		// Get stdout pipe
+		stdout, err = cmd.StdoutPipe()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error creating stdout pipe: %v\n", err)
+			os.Exit(1)
+		}
+
+		// Pass through stderr directly, or enrich it alongside stdout
+		var stderr io.ReadCloser
+		if opts.stderr {
+			stderr, err = cmd.StderrPipe()
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error creating stderr pipe: %v\n", err)
+				os.Exit(1)
+			}
+		} else {
+			cmd.Stderr = os.Stderr
+		}
+
+		// Run the command in its own process group for signal forwarding
+		setProcessGroup(cmd)
+
+		// Start the command
+		if err := cmd.Start(); err != nil {
+			exitStartError(cmdName, err)
+		}
+
+		// Enrich stderr concurrently, each line written as soon as it's read
+		if stderr != nil {
+			stderrDone = make(chan struct{})
+			go func() {
+				defer close(stderrDone)
+				if err := enrichStream(stderr, printer.stderrPrinter()); err != nil {
+					// Keep the command from blocking on a full pipe
+					io.Copy(io.Discard, stderr)
+				}
+			}()
+		}
+	}
+
+	// Relay Ctrl-C and SIGTERM to the command and keep enriching
+	// its output until it exits
+	signals := forwardSignals(cmd, shutdown)
+
+	// Process output line by line
+	err = enrichStream(tee(stdout), printer)
+	if err == errMaxLines || err == errOutputClosed {
+		// Done reading: stop the command rather than leave it blocked
+		// writing to a pipe nobody reads
+		signalGroup(cmd.Process, syscall.SIGTERM)
+		stdout.Close()
+		if stderrDone != nil {
+			<-stderrDone
+		}
+		printer.finish()
+		cmd.Wait()
+		if err := output.commit(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	if stderrDone != nil {
+		<-stderrDone
+	}
+	printer.finish()
+	if err := output.commit(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error reading command output: %v\n", err)
 	}
 
 	// Wait for command to finish
-	if err := cmd.Wait(); err != nil {
+	err = cmd.Wait()
+	signals.stop()
+	if code := signals.exitCode(); code >= 0 {
+		// Interrupted, exit like a shell would
+		os.Exit(code)
+	}
+	if err != nil {
 		// Command failed, exit with its exit code
 		if exitErr, ok := err.(*exec.ExitError); ok {
 			os.Exit(exitErr.ExitCode())
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"ip/enrich"
+)
+
+// metrics counts lines, IPs and countries for Prometheus, either written
+// to a textfile periodically or served over HTTP
+type metrics struct {
+	enricher *enrich.Enricher
+
+	mu        sync.Mutex
+	lines     uint64
+	ips       uint64
+	countries map[string]uint64
+
+	path string        // textfile to write, if any
+	stop chan struct{} // stops the textfile writer
+	done chan struct{} // closed once the writer has stopped
+}
+
+// newMetrics creates empty metrics reading cache statistics from enricher
+func newMetrics(enricher *enrich.Enricher) *metrics {
+	return &metrics{enricher: enricher, countries: make(map[string]uint64)}
+}
+
+// add counts a line and the IPs resolved in it
+func (m *metrics) add(results []enrich.Result) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.lines++
+	m.ips += uint64(len(results))
+	for _, result := range results {
+		country := "Unknown"
+		switch {
+		case result.Special:
+			country = "Local"
+		case result.Location != nil && result.Location.Country != "":
+			country = result.Location.Country
+		}
+		m.countries[country]++
+	}
+}
+
+// write prints the metrics in the Prometheus text exposition format
+func (m *metrics) write(w io.Writer) error {
+	m.mu.Lock()
+	lines, ips := m.lines, m.ips
+	countries := make([]string, 0, len(m.countries))
+	for country := range m.countries {
+		countries = append(countries, country)
+	}
+	sort.Strings(countries)
+	counts := make([]uint64, len(countries))
+	for i, country := range countries {
+		counts[i] = m.countries[country]
+	}
+	m.mu.Unlock()
+
+	hits, misses := m.enricher.CacheStats()
+	ratio := 0.0
+	if hits+misses > 0 {
+		ratio = float64(hits) / float64(hits+misses)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "# HELP ip_plus_lines_total Lines processed.\n")
+	fmt.Fprintf(&b, "# TYPE ip_plus_lines_total counter\n")
+	fmt.Fprintf(&b, "ip_plus_lines_total %d\n", lines)
+	fmt.Fprintf(&b, "# HELP ip_plus_ips_total IP addresses annotated.\n")
+	fmt.Fprintf(&b, "# TYPE ip_plus_ips_total counter\n")
+	fmt.Fprintf(&b, "ip_plus_ips_total %d\n", ips)
+	fmt.Fprintf(&b, "# HELP ip_plus_country_ips_total IP addresses annotated by country.\n")
+	fmt.Fprintf(&b, "# TYPE ip_plus_country_ips_total counter\n")
+	for i, country := range countries {
+		fmt.Fprintf(&b, "ip_plus_country_ips_total{country=\"%s\"} %d\n", escapeLabel(country), counts[i])
+	}
+	fmt.Fprintf(&b, "# HELP ip_plus_cache_hits_total Lookups answered from the cache.\n")
+	fmt.Fprintf(&b, "# TYPE ip_plus_cache_hits_total counter\n")
+	fmt.Fprintf(&b, "ip_plus_cache_hits_total %d\n", hits)
+	fmt.Fprintf(&b, "# HELP ip_plus_cache_misses_total Lookups that queried the database.\n")
+	fmt.Fprintf(&b, "# TYPE ip_plus_cache_misses_total counter\n")
+	fmt.Fprintf(&b, "ip_plus_cache_misses_total %d\n", misses)
+	fmt.Fprintf(&b, "# HELP ip_plus_cache_hit_ratio Share of lookups answered from the cache.\n")
+	fmt.Fprintf(&b, "# TYPE ip_plus_cache_hit_ratio gauge\n")
+	fmt.Fprintf(&b, "ip_plus_cache_hit_ratio %g\n", ratio)
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// escapeLabel escapes a Prometheus label value
+func escapeLabel(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+// writeFile replaces the textfile atomically, so collectors never
+// read a half-written file
+func (m *metrics) writeFile() error {
+	tmp, err := os.CreateTemp(filepath.Dir(m.path), ".ip-plus-metrics-*")
+	if err != nil {
+		return fmt.Errorf("failed to write metrics: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := m.write(tmp); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write metrics: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write metrics: %w", err)
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return fmt.Errorf("failed to write metrics: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), m.path); err != nil {
+		return fmt.Errorf("failed to write metrics: %w", err)
+	}
+	return nil
+}
+
+// startFile writes the metrics to path every interval until close
+func (m *metrics) startFile(path string, interval time.Duration) error {
+	m.path = path
+	if err := m.writeFile(); err != nil {
+		return err
+	}
+
+	m.stop = make(chan struct{})
+	m.done = make(chan struct{})
+	go func() {
+		defer close(m.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := m.writeFile(); err != nil {
+					fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+				}
+			case <-m.stop:
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+// serve exposes the metrics on http://addr/metrics
+func (m *metrics) serve(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen for metrics: %w", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		m.write(w)
+	})
+	server := &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: serveHeaderTimeout,
+		ReadTimeout:       serveReadTimeout,
+		WriteTimeout:      serveWriteTimeout,
+		IdleTimeout:       serveIdleTimeout,
+	}
+	go server.Serve(listener)
+	return nil
+}
+
+// close writes the final textfile, if one is being written
+func (m *metrics) close() {
+	if m == nil || m.stop == nil {
+		return
+	}
+	close(m.stop)
+	<-m.done
+	if err := m.writeFile(); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// fileOutput receives enriched lines for --output-file or --in-place
+//
+// With --in-place lines go to a temp file next to the input, which is
+// renamed over it once complete, so the input is never left half written.
+type fileOutput struct {
+	file *os.File
+	dest string // path the temp file replaces on commit, "" if written directly
+}
+
+// createOutput opens the file enriched lines go to, or returns nil to
+// write them to stdout
+func createOutput(opts *options) (*fileOutput, error) {
+	switch {
+	case opts.inPlace:
+		info, err := os.Stat(opts.inFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read input file: %w", err)
+		}
+		file, err := os.CreateTemp(filepath.Dir(opts.inFile), "."+filepath.Base(opts.inFile)+".*.tmp")
+		if err != nil {
+			return nil, fmt.Errorf("failed to create temp file: %w", err)
+		}
+		// Keep the permissions of the file being replaced
+		if err := file.Chmod(info.Mode().Perm()); err != nil {
+			file.Close()
+			os.Remove(file.Name())
+			return nil, fmt.Errorf("failed to create temp file: %w", err)
+		}
+		return &fileOutput{file: file, dest: opts.inFile}, nil
+	case opts.outFile != "":
+		file, err := os.Create(opts.outFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create output file: %w", err)
+		}
+		return &fileOutput{file: file}, nil
+	default:
+		return nil, nil
+	}
+}
+
+// commit closes the output and, with --in-place, replaces the input with it
+func (o *fileOutput) commit() error {
+	if o == nil {
+		return nil
+	}
+	if err := o.file.Close(); err != nil {
+		o.abort()
+		return fmt.Errorf("failed to write output file: %w", err)
+	}
+	if o.dest == "" {
+		return nil
+	}
+	if err := os.Rename(o.file.Name(), o.dest); err != nil {
+		o.abort()
+		return fmt.Errorf("failed to replace input file: %w", err)
+	}
+	return nil
+}
+
+// abort closes the output, removing the temp file of --in-place so the
+// input is left as it was
+func (o *fileOutput) abort() {
+	if o == nil {
+		return
+	}
+	o.file.Close()
+	if o.dest != "" {
+		os.Remove(o.file.Name())
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"ip/enrich"
+)
+
+// preloadIPs resolves every IP listed in path into the enricher's cache,
+// warning about lines that aren't an IP; blank lines and "#" comments
+// are ignored
+func preloadIPs(enricher *enrich.Enricher, path string, warnings io.Writer) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open preload file: %w", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for number := 1; scanner.Scan(); number++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if err := enricher.Preload(line); err != nil {
+			fmt.Fprintf(warnings, "Warning: %s:%d: %v, skipped\n", path, number, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read preload file: %w", err)
+	}
+	return nil
+}
+
+// loadOverrides reads the custom labels of an --overrides file
+func loadOverrides(path string) (*enrich.Overrides, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open overrides file: %w", err)
+	}
+	defer file.Close()
+
+	overrides, err := enrich.ParseOverrides(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return overrides, nil
+}
@@ -0,0 +1,37 @@
+//go:build !windows
+
+package main
+
+import (
+	"errors"
+	"io"
+	"os/exec"
+	"syscall"
+
+	"github.com/creack/pty"
+)
+
+// startPTY starts cmd attached to a pseudo-terminal and returns its output
+//
+// The child sees a terminal on stdin, stdout and stderr, so it keeps
+// colored and interactive output; its stderr is merged into the stream.
+func startPTY(cmd *exec.Cmd) (io.ReadCloser, error) {
+	master, err := pty.Start(cmd)
+	if err != nil {
+		return nil, err
+	}
+	return ptyReader{master}, nil
+}
+
+// ptyReader reports EOF instead of the EIO Linux returns once the child exits
+type ptyReader struct {
+	io.ReadCloser
+}
+
+func (r ptyReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if errors.Is(err, syscall.EIO) {
+		err = io.EOF
+	}
+	return n, err
+}
@@ -0,0 +1,14 @@
+//go:build windows
+
+package main
+
+import (
+	"errors"
+	"io"
+	"os/exec"
+)
+
+// startPTY is not available on Windows
+func startPTY(cmd *exec.Cmd) (io.ReadCloser, error) {
+	return nil, errors.New("--pty is not supported on Windows")
+}
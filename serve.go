@@ -0,0 +1,89 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"ip/enrich"
+)
+
+// Time limits of --serve and --metrics-addr connections, so slow or stalled clients can't
+// hold them forever; reading and writing get minutes as bodies stream
+const (
+	serveHeaderTimeout = 10 * time.Second
+	serveReadTimeout   = 5 * time.Minute
+	serveWriteTimeout  = 5 * time.Minute
+	serveIdleTimeout   = 2 * time.Minute
+)
+
+// runServe serves POST /enrich on addr until the server fails, enriching
+// the lines of each request body with the same options as the command line
+//
+// "?format=json" (or text) overrides --json for one request. Lines are
+// written back as soon as they're enriched, so large bodies stream.
+// Requests are handled concurrently, sharing the enricher's lookup cache.
+func runServe(addr string, enricher *enrich.Enricher, opts *options) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/enrich", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "use POST with the text to enrich as the body", http.StatusMethodNotAllowed)
+			return
+		}
+
+		reqOpts := *opts
+		switch r.URL.Query().Get("format") {
+		case "":
+		case "json":
+			reqOpts.json = true
+		case "text":
+			reqOpts.json = false
+		default:
+			http.Error(w, "format must be json or text", http.StatusBadRequest)
+			return
+		}
+
+		if reqOpts.json {
+			w.Header().Set("Content-Type", "application/x-ndjson")
+		} else {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		}
+
+		printer := newStreamPrinter(enricher, &reqOpts, flushWriter{w})
+		if err := enrichStream(r.Body, printer); err != nil && !errors.Is(err, errMaxLines) {
+			// Headers are out already, the client sees a truncated body
+			slog.Warn("enrich request failed", "remote", r.RemoteAddr, "err", err)
+		}
+	})
+
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: serveHeaderTimeout,
+		ReadTimeout:       serveReadTimeout,
+		WriteTimeout:      serveWriteTimeout,
+		IdleTimeout:       serveIdleTimeout,
+	}
+	slog.Info("serving", "addr", addr)
+	if err := server.ListenAndServe(); err != nil {
+		return fmt.Errorf("failed to serve: %w", err)
+	}
+	return nil
+}
+
+// flushWriter sends every write to the client right away, if the
+// response writer supports it
+type flushWriter struct {
+	w http.ResponseWriter
+}
+
+func (f flushWriter) Write(p []byte) (int, error) {
+	n, err := f.w.Write(p)
+	if flusher, ok := f.w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+	return n, err
+}
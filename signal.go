@@ -0,0 +1,74 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// catchSIGPIPE makes writes to a closed stdout pipe fail with EPIPE instead
+// of killing ip-plus, so it can stop the command before exiting
+//
+// Unlike ignoring it, handling the signal isn't inherited by the command.
+func catchSIGPIPE() {
+	signal.Notify(make(chan os.Signal, 1), syscall.SIGPIPE)
+}
+
+// shutdownGrace is how long ip-plus waits after a signal for its input to
+// end, as it does when the command writing it is interrupted too
+const shutdownGrace = time.Second
+
+// signalForwarder relays SIGINT and SIGTERM to a child's process group
+//
+// ip-plus itself keeps running so it can drain and enrich whatever the
+// child prints while shutting down, then exits once the child is gone.
+// If the child doesn't go, a second signal calls shutdown, which prints
+// what was gathered so far (the summary) and exits.
+type signalForwarder struct {
+	ch       chan os.Signal
+	received atomic.Int32 // first signal received, 0 if none
+	shutdown func(code int)
+}
+
+// forwardSignals starts relaying termination signals to the started cmd
+//
+// Without a command, when enriching piped input, the first signal calls
+// shutdown unless the input ends within shutdownGrace.
+func forwardSignals(cmd *exec.Cmd, shutdown func(code int)) *signalForwarder {
+	f := &signalForwarder{ch: make(chan os.Signal, 1), shutdown: shutdown}
+	signal.Notify(f.ch, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		for sig := range f.ch {
+			if s, ok := sig.(syscall.Signal); ok && !f.received.CompareAndSwap(0, int32(s)) {
+				// Asked again, stop waiting
+				go f.shutdown(f.exitCode())
+			}
+			if cmd == nil {
+				time.AfterFunc(shutdownGrace, func() { f.shutdown(f.exitCode()) })
+				continue
+			}
+			signalGroup(cmd.Process, sig)
+		}
+	}()
+
+	return f
+}
+
+// stop restores default signal handling
+func (f *signalForwarder) stop() {
+	signal.Stop(f.ch)
+	close(f.ch)
+}
+
+// exitCode returns the conventional 128+signal code if a signal was
+// received, or -1 otherwise
+func (f *signalForwarder) exitCode() int {
+	if sig := f.received.Load(); sig != 0 {
+		return 128 + int(sig)
+	}
+	return -1
+}
@@ -0,0 +1,17 @@
+//go:build windows
+
+package main
+
+import (
+	"os"
+	"os/exec"
+)
+
+// setProcessGroup is a no-op on Windows, where Ctrl-C already reaches
+// every process attached to the console
+func setProcessGroup(cmd *exec.Cmd) {}
+
+// signalGroup terminates p, as Windows cannot deliver other signals
+func signalGroup(p *os.Process, sig os.Signal) {
+	p.Kill()
+}
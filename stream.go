@@ -0,0 +1,478 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+	"syscall"
+
+	"ip/enrich"
+)
+
+var (
+	// errMaxLines is returned by enrichStream once opts.maxLines lines are read
+	errMaxLines = errors.New("line limit reached")
+
+	// errOutputClosed is returned by enrichStream when stdout is a pipe
+	// whose reader went away, e.g. "ip-plus cmd | head"
+	errOutputClosed = errors.New("output closed")
+)
+
+// lineSplitter wraps bufio.ScanLines to remember whether the last
+// line returned was cut off by EOF rather than ended by a newline
+//
+// Lines longer than max are returned in chunks of max bytes that are
+// marked partial, so they are printed back to back instead of aborting
+// the stream with bufio.ErrTooLong.
+type lineSplitter struct {
+	max     int
+	partial bool
+	crlf    bool // the last line ended in "\r\n", whose "\r" ScanLines drops
+}
+
+func (l *lineSplitter) split(data []byte, atEOF bool) (int, []byte, error) {
+	advance, token, err := bufio.ScanLines(data, atEOF)
+	if token == nil && !atEOF && len(data) >= l.max {
+		l.partial = true
+		return len(data), data, nil
+	}
+	l.partial = atEOF && token != nil && data[advance-1] != '\n'
+	l.crlf = token != nil && advance >= 2 && data[advance-2] == '\r' && data[advance-1] == '\n'
+	return advance, token, err
+}
+
+// newLineScanner returns a scanner over the lines of r, splitting
+// lines longer than maxLine bytes into chunks
+func newLineScanner(r io.Reader, maxLine int) (*bufio.Scanner, *lineSplitter) {
+	if maxLine <= 0 {
+		maxLine = bufio.MaxScanTokenSize
+	}
+	splitter := &lineSplitter{max: maxLine}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, min(maxLine, 64*1024)), maxLine)
+	scanner.Split(splitter.split)
+	return scanner, splitter
+}
+
+// lineJob is a line of input on its way to the output
+type lineJob struct {
+	number  int // 1-based line number in the input
+	line    string
+	seams   []int           // offsets where --unwrap joined wrapped lines
+	partial bool            // line was not ended by a newline
+	crlf    bool            // line was ended by "\r\n"
+	results []enrich.Result // resolved IPs, filled in by a worker
+	csv     bool            // results are of the --csv-column field only
+	done    chan struct{}   // closed once results are ready
+}
+
+// streamPrinter writes enriched lines to stdout or stderr
+type streamPrinter struct {
+	enricher  *enrich.Enricher
+	opts      *options
+	stats     *summary       // location tally, if enabled
+	window    *windowCounts  // per-country counts printed every window, if enabled
+	alive     *heartbeat     // reports idle stretches, if enabled
+	metrics   *metrics       // Prometheus counters, if enabled
+	tsv       *tsvSidecar    // machine-readable copy of the results, if enabled
+	context   *contextWindow // limits output to lines around foreign hits, if enabled
+	lastLabel string         // single location of the previous line, for --merge-adjacent
+	out       *bufio.Writer
+	lock      *printLock // shared with the stderr printer
+	finished  sync.Once
+}
+
+// printLock guards the tallies, the sidecar and the output buffers a
+// printer shares with its stderr printer against each other and finish,
+// which may run on the signal goroutine
+type printLock struct {
+	sync.Mutex
+	closed bool            // finish ran, nothing more is tallied or written
+	outs   []*bufio.Writer // buffers of the printers sharing the lock
+}
+
+// newStreamPrinter returns a printer writing to w through a buffer,
+// which is flushed after every line unless opts.batch is set
+func newStreamPrinter(enricher *enrich.Enricher, opts *options, w io.Writer) *streamPrinter {
+	out := bufio.NewWriterSize(w, 64*1024)
+	return &streamPrinter{
+		enricher: enricher,
+		opts:     opts,
+		out:      out,
+		lock:     &printLock{outs: []*bufio.Writer{out}},
+	}
+}
+
+// stderrPrinter returns a printer for the command's stderr that shares
+// the tallies of p, but never drops lines or stops at a line limit
+func (p *streamPrinter) stderrPrinter() *streamPrinter {
+	opts := *p.opts
+	opts.onlyIP, opts.maxLines = false, 0
+	errPrinter := newStreamPrinter(p.enricher, &opts, os.Stderr)
+	errPrinter.stats, errPrinter.window, errPrinter.metrics = p.stats, p.window, p.metrics
+	errPrinter.alive, errPrinter.lock = p.alive, p.lock
+	p.lock.outs = append(p.lock.outs, errPrinter.out)
+	return errPrinter
+}
+
+// print renders a resolved line and writes it out
+func (p *streamPrinter) print(job *lineJob) error {
+	p.alive.reset()
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if shown, err := p.tally(job); !shown || err != nil {
+		return err
+	}
+
+	var text string
+	if p.opts.json {
+		record, err := p.enricher.RenderJSON(job.line, job.results)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error encoding JSON: %v\n", err)
+			return nil
+		}
+		text = string(record) + p.lineEnding(job)
+	} else {
+		ok := false
+		if p.opts.jsonAware && !job.partial {
+			text, ok = p.enricher.RenderJSONLine(job.line)
+		}
+		if job.csv {
+			text, ok = p.enricher.RenderCSVLine(job.line, job.results, p.opts.csvComma), true
+		} else if p.opts.csvColumn > 0 && !job.partial {
+			fmt.Fprintf(os.Stderr, "Warning: line %d is not valid CSV, enriched as text\n", job.number)
+		}
+		if !ok {
+			// Not JSON, enrich as text
+			results := job.results
+			if p.opts.mergeAdj {
+				results = p.mergeAdjacent(results)
+			}
+			if len(job.seams) > 0 {
+				text = renderUnwrapped(p.enricher, job.line, results, job.seams)
+			} else {
+				text = p.enricher.Render(job.line, results)
+			}
+		}
+		// Keep the missing newline of a partial line missing
+		if !job.partial {
+			text += p.lineEnding(job)
+		}
+	}
+
+	if p.context == nil {
+		_, err := io.WriteString(p.out, text)
+		return p.written(err)
+	}
+
+	// Only show lines around foreign hits
+	for _, line := range p.context.filter(text, hasForeign(job.results)) {
+		if _, err := io.WriteString(p.out, line); err != nil {
+			return p.written(err)
+		}
+	}
+	return p.written(nil)
+}
+
+// header writes a line of ip-plus's own ahead of the enriched lines
+func (p *streamPrinter) header(line string) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	_, err := io.WriteString(p.out, line+"\n")
+	return p.written(err)
+}
+
+// tally adds the results of job to the metrics, the sidecar, the summary
+// and the window, and reports whether its line is shown; once finish ran
+// on a signal nothing is, as ip-plus is about to exit. It's called with
+// p.lock held.
+func (p *streamPrinter) tally(job *lineJob) (bool, error) {
+	if p.lock.closed {
+		return false, nil
+	}
+
+	if p.metrics != nil {
+		p.metrics.add(job.results)
+	}
+	if p.tsv != nil {
+		if err := p.tsv.write(job.number, job.results); err != nil {
+			return false, err
+		}
+	}
+
+	// Drop lines without IPs, reusing the matches found while resolving
+	if p.opts.onlyIP && len(job.results) == 0 {
+		return false, nil
+	}
+
+	if p.stats != nil {
+		p.stats.add(job.results)
+	}
+	if p.window != nil {
+		p.window.add(job.results)
+	}
+	return true, nil
+}
+
+// dittoMark stands in for a location repeated from the line above
+const dittoMark = "〃"
+
+// mergeAdjacent abbreviates the labels of results to dittoMark if they
+// all share the one location of the previous line, e.g. in dense routing
+// tables; the caller's slice is left untouched
+func (p *streamPrinter) mergeAdjacent(results []enrich.Result) []enrich.Result {
+	label := commonLabel(results)
+	if label == "" || label != p.lastLabel {
+		p.lastLabel = label
+		return results
+	}
+
+	merged := append([]enrich.Result(nil), results...)
+	for i := range merged {
+		merged[i].Label = dittoMark
+	}
+	return merged
+}
+
+// commonLabel returns the label all results share, or "" if there are
+// none or they differ
+func commonLabel(results []enrich.Result) string {
+	if len(results) == 0 {
+		return ""
+	}
+	for _, result := range results[1:] {
+		if result.Label != results[0].Label {
+			return ""
+		}
+	}
+	return results[0].Label
+}
+
+// lineEnding returns the terminator to print after job's line: "\n",
+// or "\r\n" as in the input with --keep-crlf
+func (p *streamPrinter) lineEnding(job *lineJob) string {
+	if p.opts.keepCRLF && job.crlf {
+		return "\r\n"
+	}
+	return "\n"
+}
+
+// hasForeign reports whether any of results is a foreign IP
+func hasForeign(results []enrich.Result) bool {
+	for _, result := range results {
+		if result.IsForeign() {
+			return true
+		}
+	}
+	return false
+}
+
+// written checks the result of writing a line, flushing it out
+// unless buffering for throughput
+func (p *streamPrinter) written(err error) error {
+	if err == nil && !p.opts.batch {
+		err = p.out.Flush()
+	}
+	return outputError(err)
+}
+
+// flush writes out buffered output
+func (p *streamPrinter) flush() error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return outputError(p.out.Flush())
+}
+
+// limitReached flushes the output at the line limit and returns
+// errMaxLines, or the error writing the output
+func (p *streamPrinter) limitReached() error {
+	if err := p.flush(); err != nil {
+		return err
+	}
+	return errMaxLines
+}
+
+// outputError maps a broken stdout pipe to errOutputClosed
+func outputError(err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, syscall.EPIPE):
+		return errOutputClosed
+	default:
+		return fmt.Errorf("failed to write output: %w", err)
+	}
+}
+
+// finish prints the summary and the last window, writes the final
+// metrics and closes the TSV sidecar, if enabled; only the first call,
+// at the end of the input or on a signal, does anything
+func (p *streamPrinter) finish() {
+	p.finished.Do(p.close)
+}
+
+// close is the body of finish, waiting for a line being printed; lines
+// held back by --batch or --output-file buffering are written out first
+func (p *streamPrinter) close() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.lock.closed = true
+	for _, out := range p.lock.outs {
+		if err := outputError(out.Flush()); err != nil && err != errOutputClosed {
+			fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+		}
+	}
+
+	hits, misses := p.enricher.CacheStats()
+	slog.Info("cache stats", "hits", hits, "misses", misses)
+
+	p.alive.stop()
+	p.window.close()
+	if p.stats != nil {
+		p.stats.print(os.Stderr)
+	}
+	p.metrics.close()
+	if err := p.tsv.close(); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+	}
+}
+
+// resolveJob resolves the IPs of job's line, or only of its --csv-column
+// field, after taking out earlier annotations with --strip
+func resolveJob(enricher *enrich.Enricher, opts *options, job *lineJob) {
+	if opts.strip {
+		if stripped := enricher.Strip(job.line); stripped != job.line {
+			// Wrapping seams no longer line up, print the line as one
+			job.line, job.seams = stripped, nil
+		}
+	}
+	if opts.csvColumn > 0 && !job.partial {
+		if job.results, job.csv = enricher.ResolveCSVLine(job.line, opts.csvColumn, opts.csvComma); job.csv {
+			return
+		}
+	}
+	job.results = enricher.Resolve(job.line)
+}
+
+// enrichStream reads lines from r and prints them enriched with p
+//
+// A final line without a trailing newline (e.g. a prompt) is still
+// enriched and printed as-is when the input ends. Lines longer than
+// opts.maxLine are enriched in pieces rather than dropped, and lines
+// wrapped at opts.unwrap columns are joined before enrichment. Reading
+// stops with errMaxLines as soon as opts.maxLines lines are read and
+// printed, if set, rather than when the next line arrives; lines that
+// filters like --only-ip drop count too.
+func enrichStream(r io.Reader, printer *streamPrinter) error {
+	enricher, opts := printer.enricher, printer.opts
+	scanner, splitter := newLineScanner(r, opts.maxLine)
+	reader := newLineReader(scanner, splitter, opts.unwrap)
+
+	// Count lines read against the limit
+	lines := 0
+	limited := func() bool {
+		return opts.maxLines > 0 && lines >= opts.maxLines
+	}
+
+	if opts.workers <= 1 {
+		for reader.scan() {
+			lines++
+			job := &lineJob{
+				number:  lines,
+				line:    reader.line,
+				seams:   reader.seams,
+				partial: reader.partial,
+				crlf:    reader.crlf,
+			}
+			resolveJob(enricher, opts, job)
+			if err := printer.print(job); err != nil {
+				return err
+			}
+			if limited() {
+				return printer.limitReached()
+			}
+		}
+		if err := printer.flush(); err != nil {
+			return err
+		}
+		return reader.err()
+	}
+
+	// Resolve lines in parallel; the queue keeps jobs in input order, so
+	// printing waits on each job in turn and output order is preserved
+	queue := make(chan *lineJob, opts.workers*4)
+	work := make(chan *lineJob, opts.workers*4)
+	// done is closed when printing fails, to stop the reader and workers
+	done := make(chan struct{})
+
+	var wg sync.WaitGroup
+	for i := 0; i < opts.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range work {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				resolveJob(enricher, opts, job)
+				close(job.done)
+			}
+		}()
+	}
+
+	stopped := false
+	go func() {
+		defer close(queue)
+		defer close(work)
+		for reader.scan() {
+			lines++
+			job := &lineJob{
+				number:  lines,
+				line:    reader.line,
+				seams:   reader.seams,
+				partial: reader.partial,
+				crlf:    reader.crlf,
+				done:    make(chan struct{}),
+			}
+			select {
+			case queue <- job:
+			case <-done:
+				return
+			}
+			select {
+			case work <- job:
+			case <-done:
+				return
+			}
+			if limited() {
+				// Don't wait for a line past the limit
+				stopped = true
+				return
+			}
+		}
+	}()
+
+	for job := range queue {
+		<-job.done
+		if err := printer.print(job); err != nil {
+			close(done)
+			return err
+		}
+	}
+	wg.Wait()
+	if err := printer.flush(); err != nil {
+		return err
+	}
+
+	// The queue is closed after stopped is set, so reading it is safe
+	if stopped {
+		return printer.limitReached()
+	}
+	return reader.err()
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+
+	"ip/enrich"
+)
+
+// summary tallies resolved locations across a run
+type summary struct {
+	mu        sync.Mutex
+	counts    map[string]int
+	byCountry bool // tally countries instead of full location labels
+}
+
+// newSummary creates an empty summary
+func newSummary() *summary {
+	return &summary{counts: make(map[string]int)}
+}
+
+// add counts the locations of results, grouping special IPs under "Local"
+func (s *summary) add(results []enrich.Result) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, result := range results {
+		if result.Special {
+			s.counts["Local"]++
+			continue
+		}
+		if s.byCountry && result.Location != nil && result.Location.Country != "" {
+			s.counts[result.Location.Country]++
+			continue
+		}
+		s.counts[result.Label]++
+	}
+}
+
+// take returns the tally so far and starts over with an empty one
+func (s *summary) take() *summary {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	taken := &summary{counts: s.counts, byCountry: s.byCountry}
+	s.counts = make(map[string]int)
+	return taken
+}
+
+// print writes the tally sorted by descending count, then by name
+func (s *summary) print(w io.Writer) {
+	// Lines may still be tallied when interrupted
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.counts) == 0 {
+		return
+	}
+
+	names := make([]string, 0, len(s.counts))
+	total := 0
+	for name, count := range s.counts {
+		names = append(names, name)
+		total += count
+	}
+	sort.SliceStable(names, func(i, j int) bool {
+		if s.counts[names[i]] != s.counts[names[j]] {
+			return s.counts[names[i]] > s.counts[names[j]]
+		}
+		return names[i] < names[j]
+	})
+
+	fmt.Fprintf(w, "\n%8s  %s\n", "Count", "Location")
+	for _, name := range names {
+		fmt.Fprintf(w, "%8d  %s\n", s.counts[name], name)
+	}
+	fmt.Fprintf(w, "%8d  %s\n", total, "Total")
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"ip/enrich"
+)
+
+// tsvSidecar writes a row per matched IP to a separate TSV file
+// while the enriched text goes to stdout
+type tsvSidecar struct {
+	file *os.File
+	out  *bufio.Writer
+}
+
+// tsvField keeps tabs and newlines in values from breaking rows
+var tsvField = strings.NewReplacer("\t", " ", "\n", " ", "\r", " ")
+
+// openTSV creates the sidecar file at path and writes its header
+func openTSV(path string) (*tsvSidecar, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create TSV file: %w", err)
+	}
+
+	t := &tsvSidecar{file: file, out: bufio.NewWriter(file)}
+	fmt.Fprintln(t.out, "line\tip\tcountry\tprovince\tcity")
+	return t, t.out.Flush()
+}
+
+// write adds the rows of one line and flushes them, so the file
+// keeps up with a live stream
+func (t *tsvSidecar) write(number int, results []enrich.Result) error {
+	if len(results) == 0 {
+		return nil
+	}
+
+	for _, result := range results {
+		var country, province, city string
+		if result.Location != nil {
+			country, province, city = result.Location.Country, result.Location.Province, result.Location.City
+		} else if result.Special {
+			country = "Local"
+		}
+		fmt.Fprintf(t.out, "%d\t%s\t%s\t%s\t%s\n", number, result.IP,
+			tsvField.Replace(country), tsvField.Replace(province), tsvField.Replace(city))
+	}
+	if err := t.out.Flush(); err != nil {
+		return fmt.Errorf("failed to write TSV file: %w", err)
+	}
+	return nil
+}
+
+// close flushes and closes the file
+func (t *tsvSidecar) close() error {
+	if t == nil {
+		return nil
+	}
+	if err := t.out.Flush(); err != nil {
+		t.file.Close()
+		return fmt.Errorf("failed to write TSV file: %w", err)
+	}
+	return t.file.Close()
+}
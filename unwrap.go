@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bufio"
+	"strings"
+
+	"ip/enrich"
+)
+
+// lineReader reads the lines of a scanner, rejoining lines hard-wrapped
+// at a fixed terminal width so IPs split across them are still matched
+//
+// A line exactly width columns wide that doesn't end in whitespace is
+// taken to continue on the next line. With a width of 0 lines are
+// returned as scanned.
+type lineReader struct {
+	scanner  *bufio.Scanner
+	splitter *lineSplitter
+	width    int
+
+	line    string // current line, wrapped pieces joined
+	seams   []int  // offsets in line where wrapped pieces were joined
+	partial bool   // line was not ended by a newline
+	crlf    bool   // line was ended by "\r\n"
+}
+
+// newLineReader returns a reader over the lines of scanner, which
+// must have been split by splitter
+func newLineReader(scanner *bufio.Scanner, splitter *lineSplitter, width int) *lineReader {
+	return &lineReader{scanner: scanner, splitter: splitter, width: width}
+}
+
+// scan advances to the next line, reporting false at the end of input
+func (r *lineReader) scan() bool {
+	if !r.scanner.Scan() {
+		return false
+	}
+	r.line, r.seams = r.scanner.Text(), nil
+	r.partial, r.crlf = r.splitter.partial, r.splitter.crlf
+
+	piece := r.line
+	for r.width > 0 && !r.partial && isWrapped(piece, r.width) && r.scanner.Scan() {
+		piece = r.scanner.Text()
+		r.seams = append(r.seams, len(r.line))
+		r.line += piece
+		r.partial, r.crlf = r.splitter.partial, r.splitter.crlf
+	}
+	return true
+}
+
+// err returns the first error of the underlying scanner
+func (r *lineReader) err() error {
+	return r.scanner.Err()
+}
+
+// isWrapped reports whether line looks cut off at width columns
+func isWrapped(line string, width int) bool {
+	return line != "" && !strings.ContainsAny(line[len(line)-1:], " \t") &&
+		enrich.DisplayWidth(line) == width
+}
+
+// renderUnwrapped renders a line joined by lineReader, breaking it again
+// at its seams so the output keeps the wrapped layout; an IP split by a
+// seam stays split, with its annotation after the part on the next line
+func renderUnwrapped(enricher *enrich.Enricher, line string, results []enrich.Result, seams []int) string {
+	var marked strings.Builder
+	last := 0
+	for _, seam := range seams {
+		marked.WriteString(line[last:seam])
+		marked.WriteByte('\n')
+		last = seam
+	}
+	marked.WriteString(line[last:])
+
+	// Move match offsets past the newlines inserted before them; a
+	// newline right at the end of a match stays after it
+	shift := func(pos int, start bool) int {
+		for _, seam := range seams {
+			if seam < pos || (start && seam == pos) {
+				pos++
+			}
+		}
+		return pos
+	}
+	shifted := make([]enrich.Result, len(results))
+	for i, result := range results {
+		result.Start = shift(result.Start, true)
+		result.End = shift(result.End, false)
+		result.PortEnd = shift(result.PortEnd, false)
+		shifted[i] = result
+	}
+
+	return enricher.Render(marked.String(), shifted)
+}
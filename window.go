@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"ip/enrich"
+)
+
+// windowCounts tallies IPs per country and prints the tally every
+// interval, starting over each time, for a rolling view of a live stream
+type windowCounts struct {
+	interval time.Duration
+	counts   *summary
+	w        io.Writer
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// newWindowCounts starts printing per-country counts to w every interval
+func newWindowCounts(interval time.Duration, w io.Writer) *windowCounts {
+	c := &windowCounts{
+		interval: interval,
+		counts:   &summary{counts: make(map[string]int), byCountry: true},
+		w:        w,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+
+	go func() {
+		defer close(c.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				c.print()
+			case <-c.stop:
+				return
+			}
+		}
+	}()
+	return c
+}
+
+// add counts the countries of results in the current window
+func (c *windowCounts) add(results []enrich.Result) {
+	c.counts.add(results)
+}
+
+// print writes the current window's counts, if any, and resets them
+func (c *windowCounts) print() {
+	taken := c.counts.take()
+	if len(taken.counts) == 0 {
+		return
+	}
+	fmt.Fprintf(c.w, "\n[%s] last %s:", time.Now().Format("15:04:05"), c.interval)
+	taken.print(c.w)
+}
+
+// close stops the ticker and prints the counts of the unfinished window
+func (c *windowCounts) close() {
+	if c == nil {
+		return
+	}
+	close(c.stop)
+	<-c.done
+	c.print()
+}